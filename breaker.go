@@ -0,0 +1,197 @@
+package certcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ErrCircuitOpen is returned by a CircuitBreaker which is not letting
+// operations through. LayeredCache skips layers failing with it
+// regardless of its error policy
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of a CircuitBreaker
+type CircuitState string
+
+const (
+	// CircuitClosed lets every operation through
+	CircuitClosed = CircuitState("CLOSED")
+	// CircuitOpen fails every operation with ErrCircuitOpen
+	CircuitOpen = CircuitState("OPEN")
+	// CircuitHalfOpen lets a single probe operation through, whose
+	// outcome decides whether the circuit closes or opens again
+	CircuitHalfOpen = CircuitState("HALF_OPEN")
+)
+
+// BreakerOptions configures when a CircuitBreaker opens and closes
+type BreakerOptions struct {
+	// FailureThreshold is the number of consecutive failures
+	// which opens the circuit. Defaults to 5
+	FailureThreshold int
+	// ProbeInterval is how long the circuit stays open before
+	// letting a probe operation through. Defaults to 30 seconds
+	ProbeInterval time.Duration
+	// IsFailure reports whether an error counts as a failure of the
+	// backend. Defaults to any error other than ErrCacheMiss and
+	// context cancellation
+	IsFailure func(error) bool
+	// OnStateChange, if not nil, is called on every state transition.
+	// It is called with the breaker locked and must not use it
+	OnStateChange func(from, to CircuitState)
+}
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerProbeInterval    = 30 * time.Second
+)
+
+// CircuitBreaker is an autocert.Cache which stops calling another cache
+// while it keeps failing, so that an outage of its backend fails fast
+// instead of costing a timeout on every operation
+type CircuitBreaker struct {
+	cache autocert.Cache
+	opts  BreakerOptions
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	probeAt  time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker wraps the cache with a circuit breaker, initially closed
+func NewCircuitBreaker(cache autocert.Cache, opts BreakerOptions) *CircuitBreaker {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	if opts.ProbeInterval <= 0 {
+		opts.ProbeInterval = defaultBreakerProbeInterval
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = isBackendFailure
+	}
+	return &CircuitBreaker{cache: cache, opts: opts, state: CircuitClosed}
+}
+
+func isBackendFailure(err error) bool {
+	return err != nil && !errors.Is(err, autocert.ErrCacheMiss) && !errors.Is(err, context.Canceled)
+}
+
+// State returns the current state of the circuit
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == CircuitOpen && !time.Now().Before(cb.probeAt) {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// Get returns a certificate data for the specified key.
+// If there's no such key, Get returns ErrCacheMiss.
+func (cb *CircuitBreaker) Get(ctx context.Context, key string) ([]byte, error) {
+	probe, err := cb.allow()
+	if err != nil {
+		return nil, err
+	}
+	data, err := cb.cache.Get(ctx, key)
+	cb.record(probe, err)
+	return data, err
+}
+
+// Put stores the data in the cache under the specified key.
+// Underlying implementations may use any data storage format,
+// as long as the reverse operation, Get, results in the original data.
+func (cb *CircuitBreaker) Put(ctx context.Context, key string, data []byte) error {
+	probe, err := cb.allow()
+	if err != nil {
+		return err
+	}
+	err = cb.cache.Put(ctx, key, data)
+	cb.record(probe, err)
+	return err
+}
+
+// Delete removes a certificate data from the cache under the specified key.
+// If there's no such key in the cache, Delete returns nil.
+func (cb *CircuitBreaker) Delete(ctx context.Context, key string) error {
+	probe, err := cb.allow()
+	if err != nil {
+		return err
+	}
+	err = cb.cache.Delete(ctx, key)
+	cb.record(probe, err)
+	return err
+}
+
+// Unwrap returns the wrapped cache
+func (cb *CircuitBreaker) Unwrap() autocert.Cache {
+	return cb.cache
+}
+
+// allow returns ErrCircuitOpen unless an operation may go through.
+// Once the probe interval is over, a single probe is let through
+func (cb *CircuitBreaker) allow() (probe bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case CircuitClosed:
+		return false, nil
+	case CircuitOpen:
+		if time.Now().Before(cb.probeAt) {
+			return false, ErrCircuitOpen
+		}
+		cb.transition(CircuitHalfOpen)
+	}
+	if cb.probing {
+		return false, ErrCircuitOpen
+	}
+	cb.probing = true
+	return true, nil
+}
+
+// record updates the circuit with the outcome of an operation
+func (cb *CircuitBreaker) record(probe bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if probe {
+		cb.probing = false
+		// a cancelled probe says nothing about the backend, let another one through
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+	}
+	if !cb.opts.IsFailure(err) {
+		// an operation which was let through before the circuit
+		// opened says nothing about the state of the backend now
+		if cb.state == CircuitOpen {
+			return
+		}
+		cb.failures = 0
+		if probe {
+			cb.transition(CircuitClosed)
+		}
+		return
+	}
+	cb.failures++
+	if probe || (cb.state == CircuitClosed && cb.failures >= cb.opts.FailureThreshold) {
+		cb.probeAt = time.Now().Add(cb.opts.ProbeInterval)
+		cb.transition(CircuitOpen)
+	}
+}
+
+// transition changes the state of the circuit. Must be called with mu held
+func (cb *CircuitBreaker) transition(to CircuitState) {
+	from := cb.state
+	cb.state = to
+	if to == CircuitClosed {
+		cb.failures = 0
+	}
+	if cb.opts.OnStateChange != nil && from != to {
+		cb.opts.OnStateChange(from, to)
+	}
+}
@@ -0,0 +1,83 @@
+package certcache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAndCloses(t *testing.T) {
+	ctx := context.Background()
+	down := true
+	calls := 0
+	remote := NewFunctional(func(context.Context, string) ([]byte, error) {
+		calls++
+		if down {
+			return nil, errBoom
+		}
+		return []byte("remote"), nil
+	}, nil, nil)
+	var transitions []string
+	cb := NewCircuitBreaker(remote, BreakerOptions{
+		FailureThreshold: 2,
+		ProbeInterval:    20 * time.Millisecond,
+		OnStateChange: func(from, to CircuitState) {
+			transitions = append(transitions, string(from)+">"+string(to))
+		},
+	})
+
+	for i := 0; i < 5; i++ {
+		cb.Get(ctx, "a.com")
+	}
+	if calls != 2 || cb.State() != CircuitOpen {
+		t.Fatalf("expected the circuit to open after 2 failures, got %s after %d calls", cb.State(), calls)
+	}
+	if _, err := cb.Get(ctx, "a.com"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if cb.State() != CircuitHalfOpen {
+		t.Fatalf("expected the circuit to be half open, got %s", cb.State())
+	}
+	down = false
+	if data, err := cb.Get(ctx, "a.com"); err != nil || string(data) != "remote" {
+		t.Fatalf("expected the probe to go through, got %q, %v", data, err)
+	}
+	want := []string{"CLOSED>OPEN", "OPEN>HALF_OPEN", "HALF_OPEN>CLOSED"}
+	if !slices.Equal(transitions, want) {
+		t.Fatalf("expected transitions %v, got %v", want, transitions)
+	}
+}
+
+func TestCircuitBreakerFailedProbeReopens(t *testing.T) {
+	ctx := context.Background()
+	cb := NewCircuitBreaker(failingCache(), BreakerOptions{FailureThreshold: 1, ProbeInterval: 10 * time.Millisecond})
+	cb.Get(ctx, "a.com")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := cb.Get(ctx, "a.com"); !errors.Is(err, errBoom) {
+		t.Fatalf("expected the probe to fail, got %v", err)
+	}
+	if cb.State() != CircuitOpen {
+		t.Fatalf("expected the circuit to open again, got %s", cb.State())
+	}
+}
+
+func TestLayeredSkipsOpenCircuits(t *testing.T) {
+	ctx := context.Background()
+	cb := NewCircuitBreaker(failingCache(), BreakerOptions{FailureThreshold: 1, ProbeInterval: time.Hour})
+	cb.Get(ctx, "a.com")
+	seed := NewMemory()
+	seed.Put(ctx, "a.com", []byte("seed"))
+
+	// the strict error policy doesn't stop at an open circuit
+	c := NewLayered(NewMemory(), cb, seed)
+	if data, err := c.Get(ctx, "a.com"); err != nil || string(data) != "seed" {
+		t.Fatalf("expected a hit from the layer below the open circuit, got %q, %v", data, err)
+	}
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the open circuit to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package certcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/sync/singleflight"
+)
+
+// CoalesceOptions configures which operations a Coalescing cache collapses
+type CoalesceOptions struct {
+	// Puts makes concurrent Puts of the same data under
+	// the same key share a single call to the cache
+	Puts bool
+}
+
+// Coalescing is an autocert.Cache which collapses concurrent Gets of the
+// same key into a single call to another cache, e.g. when a restarted
+// server gets many handshakes for the same name at once
+type Coalescing struct {
+	cache autocert.Cache
+	opts  CoalesceOptions
+
+	gets singleflight.Group
+	puts singleflight.Group
+}
+
+// NewCoalescing wraps the cache so that concurrent operations are coalesced
+func NewCoalescing(cache autocert.Cache, opts CoalesceOptions) *Coalescing {
+	return &Coalescing{cache: cache, opts: opts}
+}
+
+// Get returns a certificate data for the specified key.
+// If there's no such key, Get returns ErrCacheMiss.
+// The call to the cache is shared with every concurrent Get of the key, so
+// it is not cancelled with the context of any of them. A Get whose context
+// is done returns without waiting for it
+func (co *Coalescing) Get(ctx context.Context, key string) ([]byte, error) {
+	shared := context.WithoutCancel(ctx)
+	ch := co.gets.DoChan(key, func() (interface{}, error) {
+		return co.cache.Get(shared, key)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		// every caller gets its own copy since callers may modify it
+		return append([]byte(nil), res.Val.([]byte)...), nil
+	}
+}
+
+// Put stores the data in the cache under the specified key.
+// Underlying implementations may use any data storage format,
+// as long as the reverse operation, Get, results in the original data.
+// When Puts are coalesced, the call to the cache is shared with every
+// concurrent Put of the same data, so it is not cancelled with their contexts
+func (co *Coalescing) Put(ctx context.Context, key string, data []byte) error {
+	var err error
+	if co.opts.Puts {
+		sum := sha256.Sum256(data)
+		_, err, _ = co.puts.Do(key+"+"+hex.EncodeToString(sum[:]), func() (interface{}, error) {
+			return nil, co.cache.Put(context.WithoutCancel(ctx), key, data)
+		})
+	} else {
+		err = co.cache.Put(ctx, key, data)
+	}
+	// a Get in flight may have read the previous data,
+	// so the next ones must not join it
+	co.gets.Forget(key)
+	return err
+}
+
+// Delete removes a certificate data from the cache under the specified key.
+// If there's no such key in the cache, Delete returns nil.
+func (co *Coalescing) Delete(ctx context.Context, key string) error {
+	err := co.cache.Delete(ctx, key)
+	co.gets.Forget(key)
+	return err
+}
+
+// Unwrap returns the wrapped cache
+func (co *Coalescing) Unwrap() autocert.Cache {
+	return co.cache
+}
@@ -0,0 +1,85 @@
+package certcache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingCache counts the calls to Get and Put, which block until released
+func blockingCache(calls *atomic.Int32, release <-chan struct{}) *Functional {
+	return NewFunctional(func(context.Context, string) ([]byte, error) {
+		calls.Add(1)
+		<-release
+		return []byte("cert"), nil
+	}, func(context.Context, string, []byte) error {
+		calls.Add(1)
+		<-release
+		return nil
+	}, nil)
+}
+
+func TestCoalescingGets(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	co := NewCoalescing(blockingCache(&calls, release), CoalesceOptions{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := co.Get(ctx, "a.com")
+			if err != nil || string(data) != "cert" {
+				t.Errorf("expected the shared hit, got %q, %v", data, err)
+				return
+			}
+			// callers get their own copy
+			data[0] = 'x'
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected a single Get, got %d", n)
+	}
+}
+
+func TestCoalescingPuts(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	co := NewCoalescing(blockingCache(&calls, release), CoalesceOptions{Puts: true})
+
+	var wg sync.WaitGroup
+	for _, data := range []string{"a", "a", "a", "b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			co.Put(ctx, "a.com", []byte(data))
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected one Put per distinct data, got %d", n)
+	}
+}
+
+func TestCoalescingGetHonorsContext(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	defer close(release)
+	co := NewCoalescing(blockingCache(&calls, release), CoalesceOptions{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := co.Get(ctx, "a.com"); err != context.Canceled {
+		t.Fatalf("expected the Get to return when its context is done, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package certcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes a LayeredCache. It can be written as YAML or JSON, e.g.
+//
+//	write_policy: DEEP_FIRST
+//	error_policy: COOLDOWN
+//	cooldown: 1m
+//	layers:
+//	  - type: memory
+//	    max_age: 5m
+//	  - type: dir
+//	    path: /var/cache/certs
+//	  - type: s3
+//	    bucket: certs
+//	    region: us-east-1
+//
+// Policies use the values of the WritePolicy, ReadPolicy and ErrorPolicy
+// constants, and durations use the time.ParseDuration format
+type Config struct {
+	WritePolicy  string             `json:"write_policy" yaml:"write_policy"`
+	WriteQuorum  int                `json:"write_quorum" yaml:"write_quorum"`
+	WriteBehind  *WriteBehindConfig `json:"write_behind" yaml:"write_behind"`
+	ReadPolicy   string             `json:"read_policy" yaml:"read_policy"`
+	ReadQuorum   int                `json:"read_quorum" yaml:"read_quorum"`
+	ErrorPolicy  string             `json:"error_policy" yaml:"error_policy"`
+	Cooldown     string             `json:"cooldown" yaml:"cooldown"`
+	Transactions bool               `json:"transactions" yaml:"transactions"`
+	Layers       []LayerConfig      `json:"layers" yaml:"layers"`
+}
+
+// WriteBehindConfig describes the WriteBehindOptions of a LayeredCache.
+// Its presence sets the write policy to PolicyWriteBehind
+type WriteBehindConfig struct {
+	JournalDir  string `json:"journal_dir" yaml:"journal_dir"`
+	MaxAttempts int    `json:"max_attempts" yaml:"max_attempts"`
+	Backoff     string `json:"backoff" yaml:"backoff"`
+}
+
+// LayerConfig describes a single layer of a LayeredCache: its type
+// (memory, dir, s3, dynamodb, mongodb or firestore), the settings
+// relevant to that type, and its role. Alternatively, the layer can
+// be given as a URL understood by Open, in which case Type is ignored
+type LayerConfig struct {
+	Type string `json:"type" yaml:"type"`
+	URL  string `json:"url" yaml:"url"`
+
+	// dir
+	Path string `json:"path" yaml:"path"`
+	// s3 and dynamodb
+	Bucket string `json:"bucket" yaml:"bucket"`
+	Table  string `json:"table" yaml:"table"`
+	Region string `json:"region" yaml:"region"`
+	// mongodb
+	URI string `json:"uri" yaml:"uri"`
+	// firestore
+	ProjectID       string `json:"project_id" yaml:"project_id"`
+	CredentialsFile string `json:"credentials_file" yaml:"credentials_file"`
+	Collection      string `json:"collection" yaml:"collection"`
+
+	ReadOnly  bool   `json:"read_only" yaml:"read_only"`
+	WriteOnly bool   `json:"write_only" yaml:"write_only"`
+	NoPromote bool   `json:"no_promote" yaml:"no_promote"`
+	MaxAge    string `json:"max_age" yaml:"max_age"`
+}
+
+// layerBuilders build the cache of each layer type
+var layerBuilders = map[string]func(context.Context, LayerConfig) (autocert.Cache, error){
+	"memory": func(ctx context.Context, lc LayerConfig) (autocert.Cache, error) {
+		return NewMemory(), nil
+	},
+	"dir": func(ctx context.Context, lc LayerConfig) (autocert.Cache, error) {
+		if lc.Path == "" {
+			return nil, errors.New("dir layers require a path")
+		}
+		return NewDir(lc.Path), nil
+	},
+	"s3": func(ctx context.Context, lc LayerConfig) (autocert.Cache, error) {
+		return openCache(NewS3WithOptions(ctx, WithName(lc.Bucket), WithRegion(lc.Region)))
+	},
+	"dynamodb": func(ctx context.Context, lc LayerConfig) (autocert.Cache, error) {
+		return openCache(NewDynamoDBWithOptions(ctx, WithName(lc.Table), WithRegion(lc.Region)))
+	},
+	"mongodb": func(ctx context.Context, lc LayerConfig) (autocert.Cache, error) {
+		if lc.URI == "" {
+			return nil, errors.New("mongodb layers require a uri")
+		}
+		return openCache(NewMongoDBWithOptions(ctx, WithURI(lc.URI)))
+	},
+	"firestore": func(ctx context.Context, lc LayerConfig) (autocert.Cache, error) {
+		return openCache(NewFirestoreWithOptions(ctx,
+			WithProjectID(lc.ProjectID),
+			WithName(lc.Collection),
+			WithCredentialsFile(lc.CredentialsFile),
+		))
+	},
+}
+
+// ParseConfig parses a YAML or JSON (which is valid YAML) document
+func ParseConfig(data []byte) (Config, error) {
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse cache config: %w", err)
+	}
+	return cfg, nil
+}
+
+// NewLayeredFromFile builds a LayeredCache from the YAML or JSON config file
+func NewLayeredFromFile(ctx context.Context, path string) (*LayeredCache, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache config: %w", err)
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewLayeredFromConfig(ctx, cfg)
+}
+
+// NewLayeredFromConfig builds a LayeredCache from its description.
+// If it fails, the layers built so far are closed
+func NewLayeredFromConfig(ctx context.Context, cfg Config) (_ *LayeredCache, err error) {
+	if len(cfg.Layers) == 0 {
+		return nil, errors.New("cache config has no layers")
+	}
+	layers := make([]autocert.Cache, 0, len(cfg.Layers))
+	defer func() {
+		if err != nil {
+			closeCaches(ctx, layers)
+		}
+	}()
+	for i, lc := range cfg.Layers {
+		l, err := buildLayer(ctx, lc)
+		if err != nil {
+			return nil, fmt.Errorf("layer %d (%s): %w", i, lc.Type, err)
+		}
+		layers = append(layers, l)
+	}
+
+	wp := WritePolicy(cfg.WritePolicy)
+	switch {
+	case cfg.WriteQuorum > 0 && wp != "":
+		return nil, fmt.Errorf("write_quorum can't be combined with write policy %q", wp)
+	case cfg.WriteQuorum > 0 && cfg.WriteBehind != nil:
+		return nil, errors.New("write_quorum can't be combined with write_behind")
+	case cfg.WriteBehind != nil && wp != "" && wp != PolicyWriteBehind:
+		return nil, fmt.Errorf("write_behind can't be combined with write policy %q", wp)
+	case cfg.WriteQuorum > 0:
+		wp = PolicyWriteQuorum(cfg.WriteQuorum)
+	case wp == "":
+		wp = PolicyWriteDeepFirst
+	case wp != PolicyWriteDeepFirst && wp != PolicyWriteShallowFirst && wp != PolicyWriteBehind:
+		return nil, fmt.Errorf("unrecognized write policy %q", wp)
+	}
+	c := NewLayeredWithPolicy(wp, layers...)
+	switch rp := ReadPolicy(cfg.ReadPolicy); rp {
+	case "":
+	case PolicyReadSerial, PolicyReadParallel:
+		c.WithReadPolicy(rp)
+	default:
+		return nil, fmt.Errorf("unrecognized read policy %q", rp)
+	}
+	switch ep := ErrorPolicy(cfg.ErrorPolicy); ep {
+	case "":
+	case PolicyErrorStrict, PolicyErrorFallThrough, PolicyErrorCooldown:
+		c.WithErrorPolicy(ep)
+	default:
+		return nil, fmt.Errorf("unrecognized error policy %q", ep)
+	}
+	if cfg.Cooldown != "" {
+		d, err := time.ParseDuration(cfg.Cooldown)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cooldown: %w", err)
+		}
+		c.WithCooldown(d)
+	}
+	c.WithReadQuorum(cfg.ReadQuorum)
+	c.WithTransactions(cfg.Transactions)
+	if wb := cfg.WriteBehind; wb != nil {
+		opts := WriteBehindOptions{JournalDir: wb.JournalDir, MaxAttempts: wb.MaxAttempts}
+		if wb.Backoff != "" {
+			d, err := time.ParseDuration(wb.Backoff)
+			if err != nil {
+				return nil, fmt.Errorf("invalid write behind backoff: %w", err)
+			}
+			opts.Backoff = d
+		}
+		c.WithWriteBehind(opts)
+	}
+	return c, nil
+}
+
+// buildLayer builds the cache described by the layer config,
+// wrapped with its role options if it has any
+func buildLayer(ctx context.Context, lc LayerConfig) (autocert.Cache, error) {
+	// the options are checked first so that a cache is
+	// never opened only to be left behind
+	var opts []LayerOption
+	if lc.ReadOnly {
+		opts = append(opts, LayerReadOnly())
+	}
+	if lc.WriteOnly {
+		opts = append(opts, LayerWriteOnly())
+	}
+	if lc.NoPromote {
+		opts = append(opts, LayerNoPromote())
+	}
+	if lc.MaxAge != "" {
+		d, err := time.ParseDuration(lc.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max age: %w", err)
+		}
+		opts = append(opts, LayerMaxAge(d))
+	}
+
+	var (
+		cache autocert.Cache
+		err   error
+	)
+	if lc.URL != "" {
+		cache, err = Open(ctx, lc.URL)
+	} else if build, ok := layerBuilders[lc.Type]; ok {
+		cache, err = build(ctx, lc)
+	} else {
+		return nil, fmt.Errorf("unrecognized layer type %q", lc.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(opts) == 0 {
+		return cache, nil
+	}
+	return Layer(cache, opts...), nil
+}
@@ -0,0 +1,110 @@
+package certcache
+
+import (
+	"context"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// closableMemory is a Memory implementing Lifecycle
+type closableMemory struct {
+	*Memory
+	closed atomic.Bool
+}
+
+func (m *closableMemory) Ping(ctx context.Context) error  { return nil }
+func (m *closableMemory) Close(ctx context.Context) error { m.closed.Store(true); return nil }
+func (m *closableMemory) Ready() bool                     { return !m.closed.Load() }
+
+// opened holds the caches opened under the closetest scheme
+var opened []*closableMemory
+
+func init() {
+	Register("closetest", func(ctx context.Context, u *url.URL) (autocert.Cache, error) {
+		m := &closableMemory{Memory: NewMemory()}
+		opened = append(opened, m)
+		return m, nil
+	})
+}
+
+// expectClosed checks that every cache opened since the given count was closed
+func expectClosed(t *testing.T, since int) {
+	t.Helper()
+	if len(opened) == since {
+		t.Fatal("expected a cache to be opened")
+	}
+	for _, m := range opened[since:] {
+		if !m.closed.Load() {
+			t.Fatal("expected the opened cache to be closed")
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+write_policy: SHALLOW_FIRST
+error_policy: COOLDOWN
+cooldown: 1m
+layers:
+  - type: memory
+    max_age: 5m
+  - url: mem://
+    read_only: true
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %s", err)
+	}
+	c, err := NewLayeredFromConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewLayeredFromConfig failed: %s", err)
+	}
+	if len(c.layers) != 2 || !c.layers[1].readOnly || c.layers[0].maxAge == 0 {
+		t.Fatal("expected the layer roles to be applied")
+	}
+}
+
+func TestNewLayeredFromConfigClosesLayersOnFailure(t *testing.T) {
+	since := len(opened)
+	_, err := NewLayeredFromConfig(context.Background(), Config{
+		Layers: []LayerConfig{{URL: "closetest://"}, {Type: "bogus"}},
+	})
+	if err == nil {
+		t.Fatal("expected an unrecognized layer type to fail")
+	}
+	expectClosed(t, since)
+
+	since = len(opened)
+	_, err = NewLayeredFromConfig(context.Background(), Config{
+		ReadPolicy: "bogus",
+		Layers:     []LayerConfig{{URL: "closetest://", ReadOnly: true}},
+	})
+	if err == nil {
+		t.Fatal("expected an unrecognized read policy to fail")
+	}
+	expectClosed(t, since)
+}
+
+func TestNewLayeredFromConfigRejectsConflictingWritePolicies(t *testing.T) {
+	layers := []LayerConfig{{Type: "memory"}, {Type: "memory"}}
+	for _, cfg := range []Config{
+		{WriteQuorum: 2, WritePolicy: string(PolicyWriteDeepFirst), Layers: layers},
+		{WriteQuorum: 2, WriteBehind: &WriteBehindConfig{}, Layers: layers},
+		{WritePolicy: string(PolicyWriteShallowFirst), WriteBehind: &WriteBehindConfig{}, Layers: layers},
+		{WritePolicy: string(PolicyWriteDeepFirst), WriteBehind: &WriteBehindConfig{}, Layers: layers},
+	} {
+		if _, err := NewLayeredFromConfig(context.Background(), cfg); err == nil {
+			t.Fatalf("expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestOpenLayeredClosesLayersOnFailure(t *testing.T) {
+	since := len(opened)
+	if _, err := Open(context.Background(), "layered:closetest://,bogus://"); err == nil {
+		t.Fatal("expected an unregistered scheme to fail")
+	}
+	expectClosed(t, since)
+}
@@ -0,0 +1,91 @@
+package certcache
+
+import (
+	"context"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Dir is an autocert.DirCache whose keys can be listed
+type Dir struct {
+	autocert.DirCache
+}
+
+// NewDir returns a file system certificate cache rooted at the given directory
+func NewDir(path string) *Dir {
+	return &Dir{DirCache: autocert.DirCache(path)}
+}
+
+// List iterates, in sorted order, over the keys starting with prefix.
+// The temporary files of a Put in progress are skipped
+func (d *Dir) List(ctx context.Context, prefix string) iter.Seq2[KeyInfo, error] {
+	return func(yield func(KeyInfo, error) bool) {
+		entries, err := os.ReadDir(string(d.DirCache))
+		if err != nil {
+			// like Get, treat a missing directory as an empty cache
+			if !os.IsNotExist(err) {
+				yield(KeyInfo{}, err)
+			}
+			return
+		}
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				yield(KeyInfo{}, err)
+				return
+			}
+			if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), prefix) || isDirTempFile(entry.Name()) {
+				continue
+			}
+			fi, err := entry.Info()
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				yield(KeyInfo{}, err)
+				return
+			}
+			if !yield(KeyInfo{Key: entry.Name(), Size: fi.Size(), ModTime: fi.ModTime()}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// isDirTempFile returns whether a file name is one of the temporary files
+// autocert.DirCache writes a key to before renaming it, i.e. the key followed
+// by random digits. Only the keys autocert writes are recognized, since
+// others may legitimately end in digits
+func isDirTempFile(name string) bool {
+	// http-01 tokens end in digits of their own
+	if _, random, ok := strings.Cut(name, autocertHTTPTokenSuffix); ok {
+		return random != "" && strings.Trim(random, "0123456789") == ""
+	}
+	key := strings.TrimRight(name, "0123456789")
+	if key == name {
+		return false
+	}
+	class, domain := ParseKey(key)
+	if class != KeyClassCert {
+		return true
+	}
+	// domain names end in a top-level domain, never in a digit
+	return strings.Contains(domain, ".") && unicode.IsLetter(rune(domain[len(domain)-1]))
+}
+
+// Stat describes a single key.
+// If there's no such key, Stat returns ErrCacheMiss.
+func (d *Dir) Stat(ctx context.Context, key string) (KeyInfo, error) {
+	fi, err := os.Stat(filepath.Join(string(d.DirCache), filepath.Clean("/"+key)))
+	if os.IsNotExist(err) {
+		return KeyInfo{}, autocert.ErrCacheMiss
+	}
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	return KeyInfo{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
@@ -3,12 +3,12 @@ package certcache
 import (
 	"context"
 	"fmt"
-	"net/http"
+	"iter"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"golang.org/x/crypto/acme/autocert"
@@ -20,64 +20,99 @@ type DynamoDB struct {
 	table       string
 	priKeyname  string // key
 	dataKeyname string // data
+	modKeyname  string // last write, as unix nanoseconds
+	timeout     time.Duration
+	readiness
 }
 
 const (
-	defaultDynamoDBTableName  = "certcache"
-	defaultDynamoDBRegion     = "us-west-2"
-	defaultDynamoDBPriKeyName = "id"
-	defaultDynamoDBTimeout    = 10 * time.Second
+	defaultDynamoDBTableName   = "certcache"
+	defaultDynamoDBRegion      = "us-west-2"
+	defaultDynamoDBPriKeyName  = "id"
+	defaultDynamoDBDataKeyName = "data"
+	defaultDynamoDBModKeyName  = "modified"
+	defaultDynamoDBTimeout     = 10 * time.Second
 )
 
-// NewDynamoDB returns a DynamoDB certificate cache
+// NewDynamoDB returns a DynamoDB certificate cache. If the AWS session can't be
+// configured, the error is logged and every request fails with it.
+// Use NewDynamoDBWithOptions to handle that error instead
 func NewDynamoDB(credentials *credentials.Credentials, region, table string) *DynamoDB {
-	if table == "" {
-		table = defaultDynamoDBTableName
+	// with a lenient session, the constructor can't fail
+	ddb, _ := NewDynamoDBWithOptions(context.Background(),
+		WithAWSCredentials(credentials),
+		WithRegion(region),
+		WithName(table),
+		withLenientSession(),
+	)
+	return ddb
+}
+
+// NewDynamoDBWithOptions returns a DynamoDB certificate cache. The table is given
+// with WithName, and a pre-built client can be given with WithDynamoDBClient
+func NewDynamoDBWithOptions(ctx context.Context, opts ...Option) (*DynamoDB, error) {
+	o := newOptions(opts)
+	if o.name == "" {
+		o.name = defaultDynamoDBTableName
 	}
-	if region == "" {
-		region = defaultDynamoDBRegion
+	if o.timeout == 0 {
+		o.timeout = defaultDynamoDBTimeout
 	}
-	svc := dynamodb.New(session.New(), &aws.Config{
-		Credentials: credentials,
-		Region:      aws.String(region),
-		HTTPClient:  &http.Client{Timeout: defaultS3CertCacheTimeout},
-	})
-	return &DynamoDB{
-		client:     svc,
-		table:      table,
-		priKeyname: defaultDynamoDBPriKeyName,
+	client := o.dynamoDBClient
+	if client == nil {
+		sess, err := newAWSSession(o, defaultDynamoDBRegion)
+		if err != nil {
+			return nil, err
+		}
+		client = dynamodb.New(sess)
 	}
+	return &DynamoDB{
+		client:      client,
+		table:       o.name,
+		priKeyname:  defaultDynamoDBPriKeyName,
+		dataKeyname: defaultDynamoDBDataKeyName,
+		modKeyname:  defaultDynamoDBModKeyName,
+		timeout:     o.timeout,
+	}, nil
 }
 
 // Get returns a certificate data for the specified key.
 // If there's no such key, Get returns ErrCacheMiss.
 func (ddb *DynamoDB) Get(ctx context.Context, key string) ([]byte, error) {
-	result, err := ddb.client.GetItem(&dynamodb.GetItemInput{
+	ctx, cancel := withTimeout(ctx, ddb.timeout)
+	defer cancel()
+	result, err := ddb.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(ddb.table),
 		Key:       buildPrimaryKey(ddb.priKeyname, key),
 	})
-	if err != nil || result == nil {
-		return nil, fmt.Errorf("could not fetch object %s: %s", key, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch object %s: %w", key, awsError(err))
 	}
 	if _, ok := result.Item[ddb.priKeyname]; !ok {
 		return nil, autocert.ErrCacheMiss
 	}
-	data := *result.Item[ddb.dataKeyname].S
-	return []byte(data), nil
+	data, ok := result.Item[ddb.dataKeyname]
+	if !ok || data.S == nil {
+		return nil, fmt.Errorf("object %s has no %s attribute: %w", key, ddb.dataKeyname, ErrCorrupt)
+	}
+	return []byte(*data.S), nil
 }
 
 // Put stores the data in the cache under the specified key.
 // Underlying implementations may use any data storage format,
 // as long as the reverse operation, Get, results in the original data.
 func (ddb *DynamoDB) Put(ctx context.Context, key string, data []byte) error {
-	if _, err := ddb.client.PutItem(&dynamodb.PutItemInput{
+	ctx, cancel := withTimeout(ctx, ddb.timeout)
+	defer cancel()
+	if _, err := ddb.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
 		TableName: aws.String(ddb.table),
 		Item: map[string]*dynamodb.AttributeValue{
 			ddb.priKeyname:  {S: aws.String(key)},
 			ddb.dataKeyname: {S: aws.String(string(data))},
+			ddb.modKeyname:  {N: aws.String(strconv.FormatInt(time.Now().UnixNano(), 10))},
 		},
 	}); err != nil {
-		return fmt.Errorf("could not store object %s: %s", key, err)
+		return fmt.Errorf("could not store object %s: %w", key, awsError(err))
 	}
 	return nil
 }
@@ -85,15 +120,101 @@ func (ddb *DynamoDB) Put(ctx context.Context, key string, data []byte) error {
 // Delete removes a certificate data from the cache under the specified key.
 // If there's no such key in the cache, Delete returns nil.
 func (ddb *DynamoDB) Delete(ctx context.Context, key string) error {
-	if _, err := ddb.client.DeleteItem(&dynamodb.DeleteItemInput{
+	ctx, cancel := withTimeout(ctx, ddb.timeout)
+	defer cancel()
+	if _, err := ddb.client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
 		TableName: aws.String(ddb.table),
 		Key:       buildPrimaryKey(ddb.priKeyname, key),
 	}); err != nil {
-		return fmt.Errorf("could not delete object %s: %s", key, err)
+		return fmt.Errorf("could not delete object %s: %w", key, awsError(err))
 	}
 	return nil
 }
 
+// Ping checks that the table exists and can be reached
+func (ddb *DynamoDB) Ping(ctx context.Context) error {
+	return ddb.ping(func() error {
+		ctx, cancel := withTimeout(ctx, ddb.timeout)
+		defer cancel()
+		if _, err := ddb.client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(ddb.table),
+		}); err != nil {
+			return fmt.Errorf("could not reach table %s: %w", ddb.table, awsError(err))
+		}
+		return nil
+	})
+}
+
+// Close marks the cache closed. The DynamoDB client holds no
+// connection which needs to be released
+func (ddb *DynamoDB) Close(ctx context.Context) error {
+	return ddb.close(func() error { return nil })
+}
+
+// List iterates over the keys starting with prefix. Since it scans the whole
+// table, it is meant for audits rather than for the request path. ModTime is
+// zero for items written before it was recorded
+func (ddb *DynamoDB) List(ctx context.Context, prefix string) iter.Seq2[KeyInfo, error] {
+	return func(yield func(KeyInfo, error) bool) {
+		input := &dynamodb.ScanInput{TableName: aws.String(ddb.table)}
+		if prefix != "" {
+			input.FilterExpression = aws.String("begins_with(#k, :prefix)")
+			input.ExpressionAttributeNames = map[string]*string{"#k": aws.String(ddb.priKeyname)}
+			input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+				":prefix": {S: aws.String(prefix)},
+			}
+		}
+		stopped := false
+		err := ddb.client.ScanPagesWithContext(ctx, input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				if !yield(ddb.info(item), nil) {
+					stopped = true
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil && !stopped {
+			yield(KeyInfo{}, fmt.Errorf("could not scan table %s: %w", ddb.table, awsError(err)))
+		}
+	}
+}
+
+// Stat describes a single key.
+// If there's no such key, Stat returns ErrCacheMiss.
+func (ddb *DynamoDB) Stat(ctx context.Context, key string) (KeyInfo, error) {
+	ctx, cancel := withTimeout(ctx, ddb.timeout)
+	defer cancel()
+	result, err := ddb.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(ddb.table),
+		Key:       buildPrimaryKey(ddb.priKeyname, key),
+	})
+	if err != nil {
+		return KeyInfo{}, fmt.Errorf("could not fetch object %s: %w", key, awsError(err))
+	}
+	if _, ok := result.Item[ddb.priKeyname]; !ok {
+		return KeyInfo{}, autocert.ErrCacheMiss
+	}
+	return ddb.info(result.Item), nil
+}
+
+// info describes an item of the table
+func (ddb *DynamoDB) info(item map[string]*dynamodb.AttributeValue) KeyInfo {
+	var info KeyInfo
+	if v, ok := item[ddb.priKeyname]; ok {
+		info.Key = aws.StringValue(v.S)
+	}
+	if v, ok := item[ddb.dataKeyname]; ok {
+		info.Size = int64(len(aws.StringValue(v.S)))
+	}
+	if v, ok := item[ddb.modKeyname]; ok {
+		if nanos, err := strconv.ParseInt(aws.StringValue(v.N), 10, 64); err == nil {
+			info.ModTime = time.Unix(0, nanos)
+		}
+	}
+	return info
+}
+
 func buildPrimaryKey(primaryKey, objectKey string) map[string]*dynamodb.AttributeValue {
 	return map[string]*dynamodb.AttributeValue{
 		primaryKey: {
@@ -0,0 +1,312 @@
+package certcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DynamoDBStream consumes the DynamoDB stream of a certcache table and
+// reports the keys that are written to or deleted from the table
+type DynamoDBStream struct {
+	client       dynamodbstreamsiface.DynamoDBStreamsAPI
+	streamARN    string
+	priKeyname   string
+	checkpoints  autocert.Cache
+	pollInterval time.Duration
+	shardRefresh time.Duration
+	maxBackoff   time.Duration
+}
+
+const (
+	defaultDynamoDBStreamPollInterval = 1 * time.Second
+	defaultDynamoDBStreamShardRefresh = 30 * time.Second
+	defaultDynamoDBStreamMaxBackoff   = 30 * time.Second
+	dynamoDBStreamCheckpointPrefix    = "ddbstream_checkpoint+"
+)
+
+// NewDynamoDBStream returns a consumer for the DynamoDB stream with the given
+// ARN. Checkpoints are kept in memory, so a restarted consumer will re-read
+// the records still retained by the stream
+func NewDynamoDBStream(credentials *credentials.Credentials, region, streamARN string) *DynamoDBStream {
+	if region == "" {
+		region = defaultDynamoDBRegion
+	}
+	svc := dynamodbstreams.New(session.New(), &aws.Config{
+		Credentials: credentials,
+		Region:      aws.String(region),
+		HTTPClient:  &http.Client{Timeout: defaultDynamoDBTimeout},
+	})
+	return NewDynamoDBStreamWithClient(svc, streamARN, nil)
+}
+
+// NewDynamoDBStreamWithClient returns a consumer for the DynamoDB stream with
+// the given ARN which uses the provided client. Shard checkpoints are stored
+// in the checkpoints cache (e.g. an autocert.DirCache) so that a restarted
+// consumer resumes where it left off. A nil checkpoints cache keeps them in memory.
+// Checkpoints are stored under keys prefixed with "ddbstream_checkpoint+", which
+// are never reported. If the checkpoints cache is the streamed table itself,
+// every checkpoint also shows up in the stream as a record to be skipped, which
+// doubles the writes to the table; prefer a separate cache
+func NewDynamoDBStreamWithClient(client dynamodbstreamsiface.DynamoDBStreamsAPI, streamARN string, checkpoints autocert.Cache) *DynamoDBStream {
+	if checkpoints == nil {
+		checkpoints = NewMemory()
+	}
+	return &DynamoDBStream{
+		client:       client,
+		streamARN:    streamARN,
+		priKeyname:   defaultDynamoDBPriKeyName,
+		checkpoints:  checkpoints,
+		pollInterval: defaultDynamoDBStreamPollInterval,
+		shardRefresh: defaultDynamoDBStreamShardRefresh,
+		maxBackoff:   defaultDynamoDBStreamMaxBackoff,
+	}
+}
+
+// Watch reads the stream and calls fn for every record found. Calls to fn
+// are serialized. Child shards are only read once their parent shard has been
+// consumed, so events for a given key are delivered in order. Watch blocks
+// until the context is done (in which case it returns nil) or reading fails.
+// This makes DynamoDBStream a Watcher
+func (s *DynamoDBStream) Watch(ctx context.Context, fn func(Event)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errc = make(chan error, 1)
+		done = make(chan string)
+	)
+	emit := func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		fn(e)
+	}
+
+	started := map[string]bool{}
+	finished := map[string]bool{}
+	ticker := time.NewTicker(s.shardRefresh)
+	defer ticker.Stop()
+
+	for {
+		shards, err := s.describeShards(ctx)
+		if err != nil {
+			stopped := ctx.Err() != nil
+			cancel()
+			wg.Wait()
+			if stopped {
+				return nil
+			}
+			return err
+		}
+		known := map[string]bool{}
+		for _, shard := range shards {
+			known[aws.StringValue(shard.ShardId)] = true
+		}
+		for _, shard := range shards {
+			id := aws.StringValue(shard.ShardId)
+			parent := aws.StringValue(shard.ParentShardId)
+			if started[id] || (parent != "" && known[parent] && !finished[parent]) {
+				continue
+			}
+			started[id] = true
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := s.consumeShard(ctx, id, emit); err != nil {
+					select {
+					case errc <- err:
+					default:
+					}
+					return
+				}
+				select {
+				case done <- id:
+				case <-ctx.Done():
+				}
+			}()
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				return nil
+			case err := <-errc:
+				cancel()
+				wg.Wait()
+				return err
+			case id := <-done:
+				finished[id] = true
+				// a closed shard may have children that are now ready
+				break wait
+			case <-ticker.C:
+				break wait
+			}
+		}
+	}
+}
+
+func (s *DynamoDBStream) describeShards(ctx context.Context) ([]*dynamodbstreams.Shard, error) {
+	var (
+		shards []*dynamodbstreams.Shard
+		start  *string
+	)
+	for {
+		out, err := s.client.DescribeStreamWithContext(ctx, &dynamodbstreams.DescribeStreamInput{
+			StreamArn:             aws.String(s.streamARN),
+			ExclusiveStartShardId: start,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("could not describe stream %s: %w", s.streamARN, awsError(err))
+		}
+		if out.StreamDescription == nil {
+			return shards, nil
+		}
+		shards = append(shards, out.StreamDescription.Shards...)
+		if start = out.StreamDescription.LastEvaluatedShardId; start == nil {
+			return shards, nil
+		}
+	}
+}
+
+// consumeShard reads a shard until it is closed and fully read.
+// Throttled reads are retried with exponential backoff
+func (s *DynamoDBStream) consumeShard(ctx context.Context, shardID string, emit func(Event)) error {
+	iterator, err := s.shardIterator(ctx, shardID)
+	if err != nil {
+		return err
+	}
+	backoff := s.pollInterval
+	for iterator != nil {
+		out, err := s.client.GetRecordsWithContext(ctx, &dynamodbstreams.GetRecordsInput{
+			ShardIterator: iterator,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodbstreams.ErrCodeExpiredIteratorException {
+				if iterator, err = s.shardIterator(ctx, shardID); err != nil {
+					return err
+				}
+				continue
+			}
+			err = awsError(err)
+			if errors.Is(err, ErrThrottled) {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(backoff):
+				}
+				backoff = min(2*backoff, s.maxBackoff)
+				continue
+			}
+			return fmt.Errorf("could not read records from shard %s: %w", shardID, err)
+		}
+		backoff = s.pollInterval
+		reported := false
+		for _, record := range out.Records {
+			if event, ok := s.toEvent(record); ok {
+				emit(event)
+				reported = true
+			}
+		}
+		// batches holding nothing but checkpoints are not checkpointed, since
+		// checkpointing them would write another record if the checkpoints
+		// are stored in the streamed table, and so on forever. They are
+		// simply read again if the consumer restarts
+		if n := len(out.Records); reported && out.Records[n-1].Dynamodb != nil {
+			seq := aws.StringValue(out.Records[n-1].Dynamodb.SequenceNumber)
+			if err := s.checkpoints.Put(ctx, dynamoDBStreamCheckpointPrefix+shardID, []byte(seq)); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("could not checkpoint shard %s: %w", shardID, err)
+			}
+		}
+		iterator = out.NextShardIterator
+		if iterator != nil && len(out.Records) == 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(s.pollInterval):
+			}
+		}
+	}
+	return nil
+}
+
+// shardIterator returns an iterator positioned right after the last
+// checkpointed record of the shard, or at the oldest record if there is none
+func (s *DynamoDBStream) shardIterator(ctx context.Context, shardID string) (*string, error) {
+	input := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(s.streamARN),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon),
+	}
+	seq, err := s.checkpoints.Get(ctx, dynamoDBStreamCheckpointPrefix+shardID)
+	switch err {
+	case nil:
+		input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeAfterSequenceNumber)
+		input.SequenceNumber = aws.String(string(seq))
+	case autocert.ErrCacheMiss:
+	default:
+		return nil, fmt.Errorf("could not read checkpoint for shard %s: %w", shardID, err)
+	}
+	out, err := s.client.GetShardIteratorWithContext(ctx, input)
+	if err != nil {
+		// the checkpointed record is no longer retained by the stream
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodbstreams.ErrCodeTrimmedDataAccessException && input.SequenceNumber != nil {
+			input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon)
+			input.SequenceNumber = nil
+			out, err = s.client.GetShardIteratorWithContext(ctx, input)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not get iterator for shard %s: %w", shardID, awsError(err))
+		}
+	}
+	return out.ShardIterator, nil
+}
+
+func (s *DynamoDBStream) toEvent(record *dynamodbstreams.Record) (Event, bool) {
+	if record.Dynamodb == nil {
+		return Event{}, false
+	}
+	key, ok := record.Dynamodb.Keys[s.priKeyname]
+	if !ok || key.S == nil || strings.HasPrefix(*key.S, dynamoDBStreamCheckpointPrefix) {
+		return Event{}, false
+	}
+	event := Event{
+		Key:  *key.S,
+		Time: aws.TimeValue(record.Dynamodb.ApproximateCreationDateTime),
+	}
+	switch aws.StringValue(record.EventName) {
+	case dynamodbstreams.OperationTypeInsert:
+		event.Op = OpAdd
+	case dynamodbstreams.OperationTypeModify:
+		event.Op = OpPut
+	case dynamodbstreams.OperationTypeRemove:
+		event.Op = OpDelete
+	default:
+		return Event{}, false
+	}
+	return event, true
+}
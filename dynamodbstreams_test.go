@@ -0,0 +1,268 @@
+package certcache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// fakeStreams is an in-memory DynamoDB stream. Sequence numbers are the
+// position of the records in their shard, starting at 1
+type fakeStreams struct {
+	dynamodbstreamsiface.DynamoDBStreamsAPI
+
+	mu     sync.Mutex
+	shards []*fakeShard
+	// recordErrs are returned, in order, by the next calls to GetRecords
+	recordErrs []error
+}
+
+type fakeShard struct {
+	id, parent string
+	records    []*dynamodbstreams.Record
+	// trimmed is the number of records no longer retained
+	trimmed int
+	closed  bool
+}
+
+func (f *fakeStreams) addShard(id, parent string) *fakeShard {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	shard := &fakeShard{id: id, parent: parent}
+	f.shards = append(f.shards, shard)
+	return shard
+}
+
+func (f *fakeStreams) write(shard *fakeShard, op, key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	shard.records = append(shard.records, &dynamodbstreams.Record{
+		EventName: aws.String(op),
+		Dynamodb: &dynamodbstreams.StreamRecord{
+			Keys:           map[string]*dynamodb.AttributeValue{defaultDynamoDBPriKeyName: {S: aws.String(key)}},
+			SequenceNumber: aws.String(strconv.Itoa(len(shard.records) + 1)),
+		},
+	})
+}
+
+func (f *fakeStreams) shard(id string) *fakeShard {
+	for _, shard := range f.shards {
+		if shard.id == id {
+			return shard
+		}
+	}
+	return nil
+}
+
+func (f *fakeStreams) DescribeStreamWithContext(ctx aws.Context, in *dynamodbstreams.DescribeStreamInput, _ ...request.Option) (*dynamodbstreams.DescribeStreamOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	desc := &dynamodbstreams.StreamDescription{}
+	for _, shard := range f.shards {
+		s := &dynamodbstreams.Shard{ShardId: aws.String(shard.id)}
+		if shard.parent != "" {
+			s.ParentShardId = aws.String(shard.parent)
+		}
+		desc.Shards = append(desc.Shards, s)
+	}
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: desc}, nil
+}
+
+func (f *fakeStreams) GetShardIteratorWithContext(ctx aws.Context, in *dynamodbstreams.GetShardIteratorInput, _ ...request.Option) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	shard := f.shard(aws.StringValue(in.ShardId))
+	pos := shard.trimmed
+	if aws.StringValue(in.ShardIteratorType) == dynamodbstreams.ShardIteratorTypeAfterSequenceNumber {
+		seq, _ := strconv.Atoi(aws.StringValue(in.SequenceNumber))
+		if seq <= shard.trimmed {
+			return nil, awserr.New(dynamodbstreams.ErrCodeTrimmedDataAccessException, "trimmed", nil)
+		}
+		pos = seq
+	}
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String(fmt.Sprintf("%s:%d", shard.id, pos))}, nil
+}
+
+func (f *fakeStreams) GetRecordsWithContext(ctx aws.Context, in *dynamodbstreams.GetRecordsInput, _ ...request.Option) (*dynamodbstreams.GetRecordsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.recordErrs) > 0 {
+		err := f.recordErrs[0]
+		f.recordErrs = f.recordErrs[1:]
+		return nil, err
+	}
+	id, rawPos, _ := strings.Cut(aws.StringValue(in.ShardIterator), ":")
+	pos, _ := strconv.Atoi(rawPos)
+	shard := f.shard(id)
+	// serve at most two records at a time to exercise paging
+	end := min(pos+2, len(shard.records))
+	out := &dynamodbstreams.GetRecordsOutput{Records: shard.records[pos:end]}
+	if !shard.closed || end < len(shard.records) {
+		out.NextShardIterator = aws.String(fmt.Sprintf("%s:%d", id, end))
+	}
+	return out, nil
+}
+
+// watchUntil runs Watch until n events are reported and returns them
+func watchUntil(t *testing.T, s *DynamoDBStream, n int) []Event {
+	t.Helper()
+	s.pollInterval = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var events []Event
+	err := s.Watch(ctx, func(e Event) {
+		if events = append(events, e); len(events) == n {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatalf("Watch failed: %s", err)
+	}
+	if len(events) != n {
+		t.Fatalf("expected %d events, got %d: %v", n, len(events), events)
+	}
+	return events
+}
+
+func describeEvents(events []Event) string {
+	var parts []string
+	for _, e := range events {
+		parts = append(parts, fmt.Sprintf("%s %s", e.Op, e.Key))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestDynamoDBStreamParentBeforeChild(t *testing.T) {
+	f := &fakeStreams{}
+	// the child is listed first, it must still be read after its parent
+	child := f.addShard("child", "parent")
+	parent := f.addShard("parent", "")
+	f.write(parent, dynamodbstreams.OperationTypeInsert, "a.com")
+	f.write(parent, dynamodbstreams.OperationTypeModify, "a.com")
+	f.write(parent, dynamodbstreams.OperationTypeInsert, "b.com")
+	parent.closed = true
+	f.write(child, dynamodbstreams.OperationTypeRemove, "a.com")
+	child.closed = true
+
+	events := watchUntil(t, NewDynamoDBStreamWithClient(f, "arn", nil), 4)
+	want := "ADD a.com, PUT a.com, ADD b.com, DELETE a.com"
+	if got := describeEvents(events); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestDynamoDBStreamResumesFromCheckpoint(t *testing.T) {
+	f := &fakeStreams{}
+	shard := f.addShard("shard", "")
+	f.write(shard, dynamodbstreams.OperationTypeInsert, "a.com")
+	f.write(shard, dynamodbstreams.OperationTypeInsert, "b.com")
+	checkpoints := NewMemory()
+
+	watchUntil(t, NewDynamoDBStreamWithClient(f, "arn", checkpoints), 2)
+	f.write(shard, dynamodbstreams.OperationTypeRemove, "a.com")
+	events := watchUntil(t, NewDynamoDBStreamWithClient(f, "arn", checkpoints), 1)
+	if got := describeEvents(events); got != "DELETE a.com" {
+		t.Fatalf("expected to resume after the checkpoint, got %q", got)
+	}
+}
+
+func TestDynamoDBStreamTrimmedCheckpoint(t *testing.T) {
+	f := &fakeStreams{}
+	shard := f.addShard("shard", "")
+	for _, key := range []string{"a.com", "b.com", "c.com", "d.com"} {
+		f.write(shard, dynamodbstreams.OperationTypeInsert, key)
+	}
+	shard.trimmed = 2
+	checkpoints := NewMemory()
+	checkpoints.Put(context.Background(), dynamoDBStreamCheckpointPrefix+"shard", []byte("1"))
+
+	events := watchUntil(t, NewDynamoDBStreamWithClient(f, "arn", checkpoints), 2)
+	if got := describeEvents(events); got != "ADD c.com, ADD d.com" {
+		t.Fatalf("expected to restart at the oldest retained record, got %q", got)
+	}
+}
+
+func TestDynamoDBStreamRetriesThrottledReads(t *testing.T) {
+	f := &fakeStreams{}
+	shard := f.addShard("shard", "")
+	f.write(shard, dynamodbstreams.OperationTypeInsert, "a.com")
+	f.recordErrs = []error{
+		awserr.New(dynamodbstreams.ErrCodeLimitExceededException, "slow down", nil),
+		awserr.New(dynamodbstreams.ErrCodeLimitExceededException, "slow down", nil),
+	}
+	events := watchUntil(t, NewDynamoDBStreamWithClient(f, "arn", nil), 1)
+	if got := describeEvents(events); got != "ADD a.com" {
+		t.Fatalf("expected the record after throttling, got %q", got)
+	}
+}
+
+func TestDynamoDBStreamFailsOnOtherErrors(t *testing.T) {
+	f := &fakeStreams{}
+	f.addShard("shard", "")
+	f.recordErrs = []error{awserr.New("AccessDeniedException", "denied", nil)}
+	s := NewDynamoDBStreamWithClient(f, "arn", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Watch(ctx, func(Event) {}); err == nil || ctx.Err() != nil {
+		t.Fatalf("expected Watch to fail, got %v", err)
+	}
+}
+
+func TestDynamoDBStreamSkipsCheckpoints(t *testing.T) {
+	f := &fakeStreams{}
+	shard := f.addShard("shard", "")
+	f.write(shard, dynamodbstreams.OperationTypeInsert, dynamoDBStreamCheckpointPrefix+"other")
+	f.write(shard, dynamodbstreams.OperationTypeInsert, "a.com")
+	f.write(shard, dynamodbstreams.OperationTypeInsert, dynamoDBStreamCheckpointPrefix+"shard")
+	checkpoints := NewMemory()
+
+	events := watchUntil(t, NewDynamoDBStreamWithClient(f, "arn", checkpoints), 1)
+	if got := describeEvents(events); got != "ADD a.com" {
+		t.Fatalf("expected checkpoints not to be reported, got %q", got)
+	}
+	// batches holding nothing but checkpoints do not move the checkpoint,
+	// which would otherwise write a new record to a streamed checkpoint table
+	f.write(shard, dynamodbstreams.OperationTypeInsert, dynamoDBStreamCheckpointPrefix+"shard")
+	f.write(shard, dynamodbstreams.OperationTypeInsert, dynamoDBStreamCheckpointPrefix+"shard")
+	s := NewDynamoDBStreamWithClient(f, "arn", checkpoints)
+	s.pollInterval = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s.Watch(ctx, func(e Event) { t.Errorf("unexpected event %s %s", e.Op, e.Key) })
+	if seq, _ := checkpoints.Get(context.Background(), dynamoDBStreamCheckpointPrefix+"shard"); string(seq) != "2" {
+		t.Fatalf("expected the checkpoint to stay at 2, got %s", seq)
+	}
+}
+
+func TestDynamoDBStreamStopsCleanlyWhileCheckpointing(t *testing.T) {
+	f := &fakeStreams{}
+	shard := f.addShard("shard", "")
+	f.write(shard, dynamodbstreams.OperationTypeInsert, "a.com")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// the consumer is stopped while the checkpoint is being written
+	checkpoints := NewFunctional(
+		func(context.Context, string) ([]byte, error) { return nil, autocert.ErrCacheMiss },
+		func(ctx context.Context, _ string, _ []byte) error {
+			cancel()
+			return ctx.Err()
+		},
+		func(context.Context, string) error { return nil },
+	)
+	s := NewDynamoDBStreamWithClient(f, "arn", checkpoints)
+	if err := s.consumeShard(ctx, "shard", func(Event) {}); err != nil {
+		t.Fatalf("expected the shard consumer to stop cleanly, got %s", err)
+	}
+}
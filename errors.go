@@ -0,0 +1,154 @@
+package certcache
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Classes of backend failures. Errors returned by the backends match one of
+// these with errors.Is when the cause of the failure is recognized, e.g.
+//
+//	if errors.Is(err, certcache.ErrThrottled) {
+//		// back off
+//	}
+var (
+	// ErrUnavailable means the backend could not be reached or failed
+	// on its side, and the operation may succeed later
+	ErrUnavailable = errors.New("backend unavailable")
+	// ErrPermissionDenied means the credentials are missing, invalid
+	// or not allowed to perform the operation
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrThrottled means the backend rejected the operation
+	// because of its rate or capacity limits
+	ErrThrottled = errors.New("throttled")
+	// ErrConflict means the operation was rejected because
+	// of a concurrent or conflicting write
+	ErrConflict = errors.New("conflict")
+	// ErrCorrupt means the data held by the backend can't be decoded
+	ErrCorrupt = errors.New("corrupt data")
+)
+
+// BackendError is an error returned by a backend along with its class,
+// one of ErrUnavailable, ErrPermissionDenied, ErrThrottled, ErrConflict
+// or ErrCorrupt. It matches both its class and its cause with errors.Is
+// and errors.As
+type BackendError struct {
+	Class error
+	Err   error
+}
+
+// Error returns the message of the cause
+func (e *BackendError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the class and the cause of the error
+func (e *BackendError) Unwrap() []error {
+	return []error{e.Class, e.Err}
+}
+
+// classify wraps err with its class. Errors which can't be classified
+// (including nil) are returned as they are
+func classify(class, err error) error {
+	if class == nil || err == nil {
+		return err
+	}
+	return &BackendError{Class: class, Err: err}
+}
+
+// awsError classifies an error returned by the AWS SDK
+func awsError(err error) error {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return err
+	}
+	switch aerr.Code() {
+	case "AccessDenied", "AccessDeniedException", "UnrecognizedClientException",
+		"InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken",
+		"ExpiredTokenException", "InvalidClientTokenId", "MissingAuthenticationToken":
+		return classify(ErrPermissionDenied, err)
+	case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded",
+		"ProvisionedThroughputExceededException", "TooManyRequestsException",
+		"LimitExceededException":
+		return classify(ErrThrottled, err)
+	case "ConditionalCheckFailedException", "TransactionConflictException",
+		"OperationAborted", "PreconditionFailed":
+		return classify(ErrConflict, err)
+	case "ServiceUnavailable", "InternalError", "InternalServerError",
+		"RequestTimeout", "RequestTimeoutException", request.ErrCodeRequestError,
+		request.ErrCodeResponseTimeout:
+		return classify(ErrUnavailable, err)
+	}
+	var rerr awserr.RequestFailure
+	if errors.As(err, &rerr) {
+		switch code := rerr.StatusCode(); {
+		case code == http.StatusForbidden || code == http.StatusUnauthorized:
+			return classify(ErrPermissionDenied, err)
+		case code == http.StatusTooManyRequests:
+			return classify(ErrThrottled, err)
+		case code == http.StatusConflict || code == http.StatusPreconditionFailed:
+			return classify(ErrConflict, err)
+		case code >= http.StatusInternalServerError:
+			return classify(ErrUnavailable, err)
+		}
+	}
+	return err
+}
+
+// Mongo server error codes and labels, as per
+// https://www.mongodb.com/docs/manual/reference/error-codes/
+const (
+	mongoCodeUnauthorized         = 13
+	mongoCodeAuthenticationFailed = 18
+	mongoCodeWriteConflict        = 112
+
+	mongoLabelTransientTransaction = "TransientTransactionError"
+	mongoLabelRetryableWrite       = "RetryableWriteError"
+)
+
+// mongoError classifies an error returned by the Mongo driver
+func mongoError(err error) error {
+	var (
+		serr    mongo.ServerError
+		labeled mongo.LabeledError
+	)
+	switch {
+	case errors.As(err, &serr) && (serr.HasErrorCode(mongoCodeUnauthorized) ||
+		serr.HasErrorCode(mongoCodeAuthenticationFailed)):
+		return classify(ErrPermissionDenied, err)
+	case mongo.IsDuplicateKeyError(err),
+		errors.As(err, &serr) && serr.HasErrorCode(mongoCodeWriteConflict):
+		return classify(ErrConflict, err)
+	case mongo.IsNetworkError(err), mongo.IsTimeout(err),
+		errors.As(err, &labeled) && (labeled.HasErrorLabel(mongoLabelTransientTransaction) ||
+			labeled.HasErrorLabel(mongoLabelRetryableWrite)),
+		errors.Is(err, mongo.ErrClientDisconnected),
+		errors.As(err, new(topology.ServerSelectionError)):
+		return classify(ErrUnavailable, err)
+	}
+	return err
+}
+
+// grpcError classifies an error returned by a gRPC client such as Firestore's
+func grpcError(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal:
+		return classify(ErrUnavailable, err)
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return classify(ErrPermissionDenied, err)
+	case codes.ResourceExhausted:
+		return classify(ErrThrottled, err)
+	case codes.Aborted, codes.AlreadyExists, codes.FailedPrecondition:
+		return classify(ErrConflict, err)
+	case codes.DataLoss:
+		return classify(ErrCorrupt, err)
+	}
+	return err
+}
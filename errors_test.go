@@ -0,0 +1,59 @@
+package certcache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyBackendErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"aws code", awsError(awserr.New("SlowDown", "slow down", nil)), ErrThrottled},
+		{"aws stream code", awsError(awserr.New("LimitExceededException", "slow down", nil)), ErrThrottled},
+		{"aws status", awsError(awserr.NewRequestFailure(awserr.New("Weird", "down", nil), 503, "id")), ErrUnavailable},
+		{"aws denied", awsError(awserr.NewRequestFailure(awserr.New("AccessDenied", "no", nil), 403, "id")), ErrPermissionDenied},
+		{"grpc unavailable", grpcError(status.Error(codes.Unavailable, "down")), ErrUnavailable},
+		{"grpc quota", grpcError(status.Error(codes.ResourceExhausted, "quota")), ErrThrottled},
+		{"mongo unauthorized", mongoError(mongo.CommandError{Code: 13}), ErrPermissionDenied},
+	}
+	for _, c := range cases {
+		wrapped := fmt.Errorf("operation failed: %w", c.err)
+		if !errors.Is(wrapped, c.want) {
+			t.Fatalf("%s: expected %v to be %v", c.name, c.err, c.want)
+		}
+		var be *BackendError
+		if !errors.As(wrapped, &be) || be.Class != c.want {
+			t.Fatalf("%s: expected a BackendError of class %v", c.name, c.want)
+		}
+	}
+}
+
+func TestClassifyKeepsUnknownErrors(t *testing.T) {
+	err := errors.New("unknown")
+	for _, classified := range []error{awsError(err), grpcError(err), mongoError(err)} {
+		if classified != err {
+			t.Fatalf("expected unknown errors to be returned as they are, got %#v", classified)
+		}
+	}
+}
+
+func TestBackendErrorKeepsCause(t *testing.T) {
+	cause := awserr.New("SlowDown", "slow down", nil)
+	err := awsError(cause)
+	if err.Error() != cause.Error() {
+		t.Fatalf("expected the message of the cause, got %q", err)
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != "SlowDown" {
+		t.Fatal("expected the cause to be reachable with errors.As")
+	}
+}
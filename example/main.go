@@ -71,6 +71,24 @@ func getLoggerLayer() *certcache.Functional {
 	)
 }
 
+// getCache builds the cache described by the file at CERTCACHE_CONFIG
+// if set, and otherwise wires up a logger, disk and Firestore layers by hand
+func getCache() autocert.Cache {
+	if path := os.Getenv("CERTCACHE_CONFIG"); path != "" {
+		cache, err := certcache.NewLayeredFromFile(context.Background(), path)
+		if err != nil {
+			log.Fatalf("failed to build cache from %s: %s", path, err)
+		}
+		return cache
+	}
+
+	firstLayer := getLoggerLayer()
+	secondLayer := autocert.DirCache(".")
+	thirdLayer := certcache.NewFirestore(os.Getenv("FIRESTORE_CREDS_PATH"), os.Getenv("FIRESTORE_PROJ_ID"))
+
+	return certcache.NewLayered(firstLayer, secondLayer, thirdLayer)
+}
+
 func main() {
 	hostnames := []string{
 		/* YOUR DOMAIN HERE (remove the example) */
@@ -82,12 +100,7 @@ func main() {
 		w.Write([]byte("server up and running!"))
 	}))
 
-	firstLayer := getLoggerLayer()
-	secondLayer := autocert.DirCache(".")
-	thirdLayer := certcache.NewFirestore(os.Getenv("FIRESTORE_CREDS_PATH"), os.Getenv("FIRESTORE_PROJ_ID"))
-
-	cache := certcache.NewLayered(firstLayer, secondLayer, thirdLayer)
-	certMgr := getCertManager(cache, hostnames...)
+	certMgr := getCertManager(getCache(), hostnames...)
 
 	startServer(server, certMgr)
 }
@@ -6,10 +6,14 @@ package certcache
 import (
 	"context"
 	"fmt"
+	"iter"
 	"log"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -18,12 +22,17 @@ import (
 // Firestore is a Google Firestore implementation of autocert.Cache
 type Firestore struct {
 	collectionName string // firestore has "collections" with "documents"
-	ctxt           context.Context
 	client         *firestore.Client
+	timeout        time.Duration
+	// ownsClient is set when the client was created by the
+	// constructor, in which case Close closes it
+	ownsClient bool
+	readiness
 }
 
 const (
 	defaultFirestoreCertCacheCollectionName = "certcache"
+	defaultFirestoreCertCacheTimeout        = 10 * time.Second
 )
 
 // NewFirestore is the default constructor for a Firestore CertCache
@@ -32,19 +41,49 @@ func NewFirestore(credsPath, projectID string) *Firestore {
 }
 
 // NewFirestoreWithCollection is a constructor for a FirestoreCertCache
-// with a custom Firestore Collection name
+// with a custom Firestore Collection name. It exits the process if the client
+// can't be created, use NewFirestoreWithOptions to handle that error instead
 func NewFirestoreWithCollection(credsPath, projectID, certsCollectionName string) *Firestore {
-	cntxt := context.Background()
-	creds := option.WithCredentialsFile(credsPath)
-	cl, err := firestore.NewClient(cntxt, projectID, creds)
+	fcc, err := NewFirestoreWithOptions(context.Background(),
+		WithCredentialsFile(credsPath),
+		WithProjectID(projectID),
+		WithName(certsCollectionName),
+	)
 	if err != nil {
 		log.Fatalf("[FIRESTORE] failed to initialize firestore client: %s", err)
 	}
-	return &Firestore{
-		ctxt:           cntxt,
-		collectionName: certsCollectionName,
-		client:         cl,
+	return fcc
+}
+
+// NewFirestoreWithOptions returns a Firestore CertCache. The collection is
+// given with WithName, and a pre-built client can be given with WithFirestoreClient.
+// WithHTTPClient is ignored, since the Firestore client speaks gRPC
+func NewFirestoreWithOptions(ctx context.Context, opts ...Option) (*Firestore, error) {
+	o := newOptions(opts)
+	if o.name == "" {
+		o.name = defaultFirestoreCertCacheCollectionName
 	}
+	if o.timeout == 0 {
+		o.timeout = defaultFirestoreCertCacheTimeout
+	}
+	client, owned := o.firestoreClient, false
+	if client == nil {
+		owned = true
+		var clientOpts []option.ClientOption
+		if o.credsFile != "" {
+			clientOpts = append(clientOpts, option.WithCredentialsFile(o.credsFile))
+		}
+		var err error
+		if client, err = firestore.NewClient(ctx, o.projectID, clientOpts...); err != nil {
+			return nil, fmt.Errorf("failed to initialize firestore client: %w", err)
+		}
+	}
+	return &Firestore{
+		collectionName: o.name,
+		client:         client,
+		timeout:        o.timeout,
+		ownsClient:     owned,
+	}, nil
 }
 
 type format struct {
@@ -54,19 +93,21 @@ type format struct {
 // Get returns a certificate data for the specified key.
 // If there's no such key, Get returns ErrCacheMiss.
 func (fcc *Firestore) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, fcc.timeout)
+	defer cancel()
 	log.Println(fmt.Sprintf("[firestore-certcache] fetching %s from firestore", key))
-	docSnapshot, err := fcc.client.Collection(fcc.collectionName).Doc(key).Get(fcc.ctxt)
+	docSnapshot, err := fcc.client.Collection(fcc.collectionName).Doc(key).Get(ctx)
 	if err != nil {
 		log.Println(fmt.Sprintf("[firestore-certcache] error fetching %s from firestore: %s", key, err))
 		if grpc.Code(err) == codes.NotFound {
 			return nil, autocert.ErrCacheMiss
 		}
-		return nil, err
+		return nil, grpcError(err)
 	}
 	var doc format
 	if err := docSnapshot.DataTo(&doc); err != nil {
 		log.Println(fmt.Sprintf("[firestore-certcache] error reading document snapshot for %s: %s", key, err))
-		return nil, err
+		return nil, classify(ErrCorrupt, err)
 	}
 	log.Println(fmt.Sprintf("[firestore-certcache] fetched %s from firestore", key))
 	return []byte(doc.Data), nil
@@ -76,11 +117,13 @@ func (fcc *Firestore) Get(ctx context.Context, key string) ([]byte, error) {
 // Underlying implementations may use any data storage format,
 // as long as the reverse operation, Get, results in the original data.
 func (fcc *Firestore) Put(ctx context.Context, key string, data []byte) error {
+	ctx, cancel := withTimeout(ctx, fcc.timeout)
+	defer cancel()
 	log.Println(fmt.Sprintf("[firestore-certcache] storing %s in firestore", key))
 	newDocRef := fcc.client.Collection(fcc.collectionName).Doc(key)
-	if _, err := newDocRef.Set(fcc.ctxt, format{Data: string(data)}); err != nil {
+	if _, err := newDocRef.Set(ctx, format{Data: string(data)}); err != nil {
 		log.Println(fmt.Sprintf("[firestore-certcache] failed to store %s in firestore", key))
-		return err
+		return grpcError(err)
 	}
 	log.Println(fmt.Sprintf("[firestore-certcache] successfully stored %s in firestore", key))
 	return nil
@@ -89,6 +132,131 @@ func (fcc *Firestore) Put(ctx context.Context, key string, data []byte) error {
 // Delete removes a certificate data from the cache under the specified key.
 // If there's no such key in the cache, Delete returns nil.
 func (fcc *Firestore) Delete(ctx context.Context, key string) error {
-	_, err := fcc.client.Collection(fcc.collectionName).Doc(key).Delete(fcc.ctxt)
-	return err
+	ctx, cancel := withTimeout(ctx, fcc.timeout)
+	defer cancel()
+	_, err := fcc.client.Collection(fcc.collectionName).Doc(key).Delete(ctx)
+	return grpcError(err)
+}
+
+// Watch listens for changes to the cert cache collection and calls fn for
+// every key that is added (OpAdd), modified (OpPut) or removed (OpDelete).
+// The documents that already exist when the listener starts are not reported.
+// Watch blocks until the context is done (in which case it returns nil) or the
+// listener fails. This makes Firestore a Watcher
+func (fcc *Firestore) Watch(ctx context.Context, fn func(Event)) error {
+	it := fcc.client.Collection(fcc.collectionName).Snapshots(ctx)
+	defer it.Stop()
+
+	initial := true
+	for {
+		snap, err := it.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to listen for changes in firestore: %w", grpcError(err))
+		}
+		// the first snapshot contains every document in the collection
+		if initial {
+			initial = false
+			continue
+		}
+		for _, change := range snap.Changes {
+			event := Event{
+				Key:    change.Doc.Ref.ID,
+				Op:     OpPut,
+				Time:   change.Doc.UpdateTime,
+				Source: fcc,
+			}
+			switch change.Kind {
+			case firestore.DocumentAdded:
+				event.Op = OpAdd
+			case firestore.DocumentRemoved:
+				event.Op = OpDelete
+				event.Time = snap.ReadTime
+			}
+			fn(event)
+		}
+	}
+}
+
+// List iterates over the keys starting with prefix, in lexicographic order
+func (fcc *Firestore) List(ctx context.Context, prefix string) iter.Seq2[KeyInfo, error] {
+	return func(yield func(KeyInfo, error) bool) {
+		q := fcc.client.Collection(fcc.collectionName).OrderBy(firestore.DocumentID, firestore.Asc)
+		if prefix != "" {
+			q = q.StartAt(prefix)
+		}
+		it := q.Documents(ctx)
+		defer it.Stop()
+		for {
+			snap, err := it.Next()
+			if err == iterator.Done {
+				return
+			}
+			if err != nil {
+				yield(KeyInfo{}, fmt.Errorf("failed to list documents in firestore: %w", grpcError(err)))
+				return
+			}
+			// keys sharing the prefix are contiguous
+			if !strings.HasPrefix(snap.Ref.ID, prefix) {
+				return
+			}
+			info, err := snapshotInfo(snap)
+			if err != nil {
+				yield(KeyInfo{}, err)
+				return
+			}
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Stat describes a single key.
+// If there's no such key, Stat returns ErrCacheMiss.
+func (fcc *Firestore) Stat(ctx context.Context, key string) (KeyInfo, error) {
+	ctx, cancel := withTimeout(ctx, fcc.timeout)
+	defer cancel()
+	snap, err := fcc.client.Collection(fcc.collectionName).Doc(key).Get(ctx)
+	if err != nil {
+		if grpc.Code(err) == codes.NotFound {
+			return KeyInfo{}, autocert.ErrCacheMiss
+		}
+		return KeyInfo{}, grpcError(err)
+	}
+	return snapshotInfo(snap)
+}
+
+func snapshotInfo(snap *firestore.DocumentSnapshot) (KeyInfo, error) {
+	var doc format
+	if err := snap.DataTo(&doc); err != nil {
+		return KeyInfo{}, fmt.Errorf("error reading document snapshot for %s: %w", snap.Ref.ID, classify(ErrCorrupt, err))
+	}
+	return KeyInfo{Key: snap.Ref.ID, Size: int64(len(doc.Data)), ModTime: snap.UpdateTime}, nil
+}
+
+// Ping checks that the collection can be queried
+func (fcc *Firestore) Ping(ctx context.Context) error {
+	return fcc.ping(func() error {
+		ctx, cancel := withTimeout(ctx, fcc.timeout)
+		defer cancel()
+		it := fcc.client.Collection(fcc.collectionName).Limit(1).Documents(ctx)
+		defer it.Stop()
+		if _, err := it.Next(); err != nil && err != iterator.Done {
+			return fmt.Errorf("failed to query firestore: %w", grpcError(err))
+		}
+		return nil
+	})
+}
+
+// Close closes the client, unless it was given with WithFirestoreClient
+func (fcc *Firestore) Close(ctx context.Context) error {
+	return fcc.close(func() error {
+		if !fcc.ownsClient {
+			return nil
+		}
+		return fcc.client.Close()
+	})
 }
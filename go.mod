@@ -1,17 +1,17 @@
 module github.com/adrianosela/certcache
 
-go 1.22
-
-toolchain go1.22.4
+go 1.23
 
 require (
 	cloud.google.com/go/firestore v1.15.0
 	github.com/aws/aws-sdk-go v1.54.2
+	github.com/redis/go-redis/v9 v9.9.0
 	go.mongodb.org/mongo-driver v1.15.1
 	golang.org/x/crypto v0.24.0
+	golang.org/x/sync v0.7.0
 	google.golang.org/api v0.184.0
 	google.golang.org/grpc v1.64.0
-	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -20,6 +20,8 @@ require (
 	cloud.google.com/go/auth/oauth2adapt v0.2.2 // indirect
 	cloud.google.com/go/compute/metadata v0.3.0 // indirect
 	cloud.google.com/go/longrunning v0.5.7 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/felixge/httpsnoop v1.0.4 // indirect
 	github.com/go-logr/logr v1.4.2 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
@@ -44,7 +46,6 @@ require (
 	go.opentelemetry.io/otel/trace v1.27.0 // indirect
 	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/oauth2 v0.21.0 // indirect
-	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.21.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
@@ -6,17 +6,43 @@ package certcache
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/acme/autocert"
 )
 
 // LayeredCache is an implementation of the autocert.Cache interface.
-// The behavior of the cache consists in checking itself for hits, and having
-// a fall back storage layer to search in the event of a cache miss
+// The behavior of the cache consists in checking its layers in order for hits,
+// falling back to the next (deeper) layer in the event of a cache miss
 type LayeredCache struct {
-	layer       autocert.Cache
-	nextLayer   *LayeredCache
-	writePolicy WritePolicy
+	layers        []*layer
+	writePolicy   WritePolicy
+	readPolicy    ReadPolicy
+	errorPolicy   ErrorPolicy
+	cooldown      time.Duration
+	readQuorum    int
+	router        Router
+	transactional bool
+	behind        *writeBehind
+}
+
+// layer is a single autocert.Cache in the chain along with its state
+type layer struct {
+	cache     autocert.Cache
+	readOnly  bool
+	noPromote bool
+	writeOnly bool
+	maxAge    time.Duration
+
+	mu             sync.Mutex
+	unhealthyUntil time.Time
+	validated      map[string]time.Time
+	revalidating   map[string]bool
+
+	counters layerCounters
 }
 
 // WritePolicy determines the order in which the layered cache executes Put
@@ -30,18 +56,60 @@ const (
 	// PolicyWriteShallowFirst will write to caches starting from the top,
 	// often least persistent, layer e.g. a struct in process heap
 	PolicyWriteShallowFirst = WritePolicy("SHALLOW_FIRST")
+	// PolicyWriteBehind will write to the top layer and return, copying the
+	// write to the deeper layers in the background (see WithWriteBehind)
+	PolicyWriteBehind = WritePolicy("WRITE_BEHIND")
 )
 
-// NewLayered returns a new layered cache given autocert.Cache implementations
+// PolicyWriteQuorum treats the layers as replicas: it will write to all
+// layers at once and succeed as soon as n of them have acknowledged the write
+func PolicyWriteQuorum(n int) WritePolicy {
+	return WritePolicy(fmt.Sprintf("%s%d", quorumPolicyPrefix, n))
+}
+
+// ReadPolicy determines how the layered cache executes Get
+type ReadPolicy string
+
+const (
+	// PolicyReadSerial will query layers one at a time starting from the top,
+	// only moving on to the next layer on a miss. This is the default behavior
+	PolicyReadSerial = ReadPolicy("SERIAL")
+	// PolicyReadParallel will query all layers at once and return the first
+	// hit, cancelling the remaining lookups. The layers above the one which
+	// hit are then filled in the background
+	PolicyReadParallel = ReadPolicy("PARALLEL")
+)
+
+// ErrorPolicy determines what Get does when a layer fails with
+// an error other than autocert.ErrCacheMiss
+type ErrorPolicy string
+
+const (
+	// PolicyErrorStrict will return the error right away.
+	// This is the default behavior
+	PolicyErrorStrict = ErrorPolicy("STRICT")
+	// PolicyErrorFallThrough will move on to the next layer as if
+	// the failing layer had missed
+	PolicyErrorFallThrough = ErrorPolicy("FALL_THROUGH")
+	// PolicyErrorCooldown will move on to the next layer and skip the
+	// failing layer altogether until its cooldown period is over. A Get
+	// which skipped a layer and found nothing fails with ErrUnavailable
+	// rather than ErrCacheMiss, since the skipped layer may hold the key
+	PolicyErrorCooldown = ErrorPolicy("COOLDOWN")
+)
+
+// errCoolingDown is reported for the layers skipped by a Get because
+// they failed recently under PolicyErrorCooldown
+var errCoolingDown = fmt.Errorf("skipped while cooling down after a failure: %w", ErrUnavailable)
+
+const (
+	defaultLayerCooldown = 30 * time.Second
+)
+
+// NewLayered returns a new layered cache given autocert.Cache implementations.
+// Layers can be given specific roles by wrapping them with Layer
 func NewLayered(layers ...autocert.Cache) *LayeredCache {
-	if len(layers) == 0 {
-		return nil
-	}
-	return &LayeredCache{
-		layer:       layers[0],
-		nextLayer:   NewLayered(layers[1:]...),
-		writePolicy: PolicyWriteDeepFirst,
-	}
+	return NewLayeredWithPolicy(PolicyWriteDeepFirst, layers...)
 }
 
 // NewLayeredWithPolicy returns a new layered cache and allows the user
@@ -50,81 +118,285 @@ func NewLayeredWithPolicy(wp WritePolicy, layers ...autocert.Cache) *LayeredCach
 	if len(layers) == 0 {
 		return nil
 	}
-	return &LayeredCache{
-		layer:       layers[0],
-		nextLayer:   NewLayeredWithPolicy(wp, layers[1:]...),
+	c := &LayeredCache{
 		writePolicy: wp,
+		readPolicy:  PolicyReadSerial,
+		errorPolicy: PolicyErrorStrict,
+		cooldown:    defaultLayerCooldown,
 	}
+	for _, l := range layers {
+		c.layers = append(c.layers, newLayer(l))
+	}
+	c.behind = newWriteBehind(c, WriteBehindOptions{})
+	return c
+}
+
+// WithReadPolicy sets the policy followed by Get to query the layers
+func (c *LayeredCache) WithReadPolicy(rp ReadPolicy) *LayeredCache {
+	c.readPolicy = rp
+	return c
+}
+
+// WithErrorPolicy sets the policy followed by Get when a layer fails
+func (c *LayeredCache) WithErrorPolicy(ep ErrorPolicy) *LayeredCache {
+	c.errorPolicy = ep
+	return c
+}
+
+// WithCooldown sets for how long a failing layer is skipped
+// when the error policy is PolicyErrorCooldown
+func (c *LayeredCache) WithCooldown(d time.Duration) *LayeredCache {
+	c.cooldown = d
+	return c
 }
 
 // Get returns a certificate data for the specified key.
 // If there's no such key, Get returns ErrCacheMiss.
+// Unless the error policy is PolicyErrorStrict, failing layers are skipped
+// and their errors are only returned (joined) if no other layer has the key.
+// Layers failing with ErrCircuitOpen are skipped under every policy
 func (c *LayeredCache) Get(ctx context.Context, key string) ([]byte, error) {
-	cert, err := c.layer.Get(ctx, key)
-	if err != nil {
-		if err == autocert.ErrCacheMiss {
-			if c.nextLayer != nil {
-				if cert, err = c.nextLayer.Get(ctx, key); err != nil {
-					return nil, err
-				}
-				// bring data into current level of cache
-				// we ignore the error since data is
-				// already in a more persistent layer
-				c.layer.Put(ctx, key, cert)
-				return cert, nil
+	if c.readQuorum > 0 {
+		return c.getQuorum(ctx, key)
+	}
+	switch c.readPolicy {
+	case PolicyReadSerial:
+		return c.getSerial(ctx, key)
+	case PolicyReadParallel:
+		return c.getParallel(ctx, key)
+	default:
+		return nil, errors.New("unrecognized read policy")
+	}
+}
+
+func (c *LayeredCache) getSerial(ctx context.Context, key string) ([]byte, error) {
+	var errs []error
+	for _, i := range c.readers(key) {
+		l := c.layers[i]
+		if !l.healthy() {
+			errs = append(errs, fmt.Errorf("layer %d: %w", i, errCoolingDown))
+			continue
+		}
+		cert, err := l.get(ctx, key)
+		if err == nil {
+			c.promote(ctx, key, cert, i)
+			if l.stale(key) {
+				go c.revalidate(key, cert, i)
 			}
-			return nil, autocert.ErrCacheMiss
+			return cert, nil
 		}
-		return nil, err
+		if err == autocert.ErrCacheMiss {
+			continue
+		}
+		// an open circuit fails fast and says the layer is known to be
+		// down, so it is skipped whatever the policy. Its error is still
+		// returned if no other layer has the key
+		if errors.Is(err, ErrCircuitOpen) {
+			errs = append(errs, fmt.Errorf("layer %d: %w", i, err))
+			continue
+		}
+		if c.errorPolicy == PolicyErrorStrict {
+			return nil, err
+		}
+		if perr := c.layerFailed(l, err); perr != nil {
+			return nil, perr
+		}
+		errs = append(errs, fmt.Errorf("layer %d: %w", i, err))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
-	return cert, nil
+	return nil, autocert.ErrCacheMiss
 }
 
-// Put stores the data in the cache under the specified key.
-// Underlying implementations may use any data storage format,
-// as long as the reverse operation, Get, results in the original data.
-func (c *LayeredCache) Put(ctx context.Context, key string, data []byte) error {
-	switch c.writePolicy {
-	case PolicyWriteDeepFirst:
-		if c.nextLayer != nil {
-			if err := c.nextLayer.Put(ctx, key, data); err != nil {
-				return err
+// getParallel queries every layer at once. Since the layers answer in any
+// order, errors never prevent a hit from a deeper layer. If nothing hits,
+// the error of the shallowest failing layer is returned under
+// PolicyErrorStrict and all errors are joined otherwise
+func (c *LayeredCache) getParallel(ctx context.Context, key string) ([]byte, error) {
+	type result struct {
+		index int
+		cert  []byte
+		err   error
+	}
+	lookupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, len(c.layers))
+	errs := make([]error, len(c.layers))
+	failed := false
+	pending := 0
+	for _, i := range c.readers(key) {
+		l := c.layers[i]
+		if !l.healthy() {
+			errs[i] = fmt.Errorf("layer %d: %w", i, errCoolingDown)
+			failed = true
+			continue
+		}
+		pending++
+		go func() {
+			cert, err := l.get(lookupCtx, key)
+			results <- result{index: i, cert: cert, err: err}
+		}()
+	}
+
+	for ; pending > 0; pending-- {
+		r := <-results
+		if r.err == nil {
+			cancel()
+			go c.promote(context.WithoutCancel(ctx), key, r.cert, r.index)
+			if c.layers[r.index].stale(key) {
+				go c.revalidate(key, r.cert, r.index)
 			}
+			return r.cert, nil
 		}
-		return c.layer.Put(ctx, key, data)
-	case PolicyWriteShallowFirst:
-		if err := c.layer.Put(ctx, key, data); err != nil {
-			return err
+		if r.err == autocert.ErrCacheMiss {
+			continue
+		}
+		if errors.Is(r.err, ErrCircuitOpen) {
+			errs[r.index] = fmt.Errorf("layer %d: %w", r.index, r.err)
+			failed = true
+			continue
 		}
-		if c.nextLayer != nil {
-			return c.nextLayer.Put(ctx, key, data)
+		if err := c.layerFailed(c.layers[r.index], r.err); err != nil {
+			return nil, err
 		}
+		errs[r.index] = fmt.Errorf("layer %d: %w", r.index, r.err)
+		failed = true
+	}
+	if !failed {
+		return nil, autocert.ErrCacheMiss
+	}
+	if c.errorPolicy == PolicyErrorStrict {
+		for _, err := range errs {
+			if err != nil {
+				return nil, errors.Unwrap(err)
+			}
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// layerFailed applies the error policy to a layer which failed with err.
+// It returns a non nil error if the policy itself is not valid
+func (c *LayeredCache) layerFailed(l *layer, err error) error {
+	switch c.errorPolicy {
+	case PolicyErrorStrict, PolicyErrorFallThrough:
+		return nil
+	case PolicyErrorCooldown:
+		l.markUnhealthy(c.cooldown)
 		return nil
 	default:
-		return errors.New("unrecognized write policy")
+		return errors.New("unrecognized error policy")
+	}
+}
+
+// promote brings data found in the layer at index hit into every layer above it.
+// We ignore errors since the data is already in a more persistent layer
+func (c *LayeredCache) promote(ctx context.Context, key string, data []byte, hit int) {
+	if c.layers[hit].noPromote {
+		return
+	}
+	allowed := c.routed(key)
+	for i := hit - 1; i >= 0; i-- {
+		if allowed[i] && c.layers[i].promotable() && c.layers[i].healthy() {
+			if c.layers[i].apply(ctx, OpPut, key, data) == nil {
+				c.layers[i].counters.promotions.Add(1)
+			}
+		}
 	}
 }
 
+// Put stores the data in the cache under the specified key.
+// Underlying implementations may use any data storage format,
+// as long as the reverse operation, Get, results in the original data.
+func (c *LayeredCache) Put(ctx context.Context, key string, data []byte) error {
+	return c.write(ctx, OpPut, key, data)
+}
+
 // Delete removes a certificate data from the cache under the specified key.
 // If there's no such key in the cache, Delete returns nil.
 func (c *LayeredCache) Delete(ctx context.Context, key string) error {
+	return c.write(ctx, OpDelete, key, nil)
+}
+
+// write applies the operation to the layers as dictated by the write policy
+func (c *LayeredCache) write(ctx context.Context, op Op, key string, data []byte) error {
 	switch c.writePolicy {
 	case PolicyWriteDeepFirst:
-		if c.nextLayer != nil {
-			if err := c.nextLayer.Delete(ctx, key); err != nil {
+		order := c.writers(key)
+		slices.Reverse(order)
+		return c.writeInOrder(ctx, order, op, key, data)
+	case PolicyWriteShallowFirst:
+		return c.writeInOrder(ctx, c.writers(key), op, key, data)
+	case PolicyWriteBehind:
+		order := c.writers(key)
+		if len(order) > 0 && order[0] == 0 {
+			if err := c.writeInOrder(ctx, order[:1], op, key, data); err != nil {
 				return err
 			}
+			order = order[1:]
 		}
-		return c.layer.Delete(ctx, key)
-	case PolicyWriteShallowFirst:
-		if err := c.layer.Delete(ctx, key); err != nil {
-			return err
-		}
-		if c.nextLayer != nil {
-			return c.nextLayer.Delete(ctx, key)
+		if len(order) == 0 {
+			return nil
 		}
-		return nil
+		return c.behind.enqueue(op, key, data)
 	default:
+		if n, ok := c.writePolicy.quorum(); ok {
+			return c.writeQuorum(ctx, n, op, key, data)
+		}
 		return errors.New("unrecognized write policy")
 	}
 }
+
+// apply executes a Put or a Delete on the layer, counting the outcome
+func (l *layer) apply(ctx context.Context, op Op, key string, data []byte) error {
+	start := time.Now()
+	var err error
+	if op == OpDelete {
+		err = l.cache.Delete(ctx, key)
+	} else {
+		err = l.cache.Put(ctx, key, data)
+	}
+	l.counters.writes.Add(1)
+	l.counters.writeLatency.Add(int64(time.Since(start)))
+	if err != nil {
+		l.counters.writeErrors.Add(1)
+		return err
+	}
+	l.track(op, key)
+	return nil
+}
+
+func (l *layer) healthy() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().After(l.unhealthyUntil)
+}
+
+func (l *layer) markUnhealthy(cooldown time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.unhealthyUntil = time.Now().Add(cooldown)
+}
+
+// Subscribe makes the layered cache listen for change events on the bus.
+// When a layer reports a change to a key, the key is evicted from every
+// layer above it, so that the next Get brings the new data up from the
+// layer that changed. Events from an unknown source evict the key from
+// every layer except the deepest one. The returned function unsubscribes
+func (c *LayeredCache) Subscribe(b *Bus) func() {
+	return b.Subscribe(func(e Event) {
+		for _, l := range c.layers[:len(c.layers)-1] {
+			if wraps(l.cache, e.Source) {
+				return
+			}
+			if !l.promotable() {
+				continue
+			}
+			// eviction is best effort, a failure only delays
+			// the new data reaching this layer
+			l.cache.Delete(context.Background(), e.Key)
+		}
+	})
+}
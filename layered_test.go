@@ -0,0 +1,93 @@
+package certcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var errBoom = errors.New("boom")
+
+// failingCache fails every operation with errBoom
+func failingCache() *Functional {
+	return NewFunctional(
+		func(context.Context, string) ([]byte, error) { return nil, errBoom },
+		func(context.Context, string, []byte) error { return errBoom },
+		func(context.Context, string) error { return errBoom },
+	)
+}
+
+func TestLayeredErrorPolicyStrict(t *testing.T) {
+	ctx := context.Background()
+	deep := NewMemory()
+	deep.Put(ctx, "k", []byte("v"))
+	c := NewLayered(failingCache(), deep)
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, errBoom) {
+		t.Fatalf("expected the layer error, got %v", err)
+	}
+}
+
+func TestLayeredErrorPolicyFallThrough(t *testing.T) {
+	ctx := context.Background()
+	top, deep := NewMemory(), NewMemory()
+	deep.Put(ctx, "k", []byte("v"))
+	for _, policy := range []ReadPolicy{PolicyReadSerial, PolicyReadParallel} {
+		c := NewLayered(failingCache(), top, deep).
+			WithErrorPolicy(PolicyErrorFallThrough).
+			WithReadPolicy(policy)
+		if data, err := c.Get(ctx, "k"); err != nil || string(data) != "v" {
+			t.Fatalf("%s: expected a hit from the deepest layer, got %q, %v", policy, data, err)
+		}
+		// a failure is not a miss, autocert must not order a new certificate
+		if _, err := c.Get(ctx, "missing"); !errors.Is(err, errBoom) {
+			t.Fatalf("%s: expected the layer error, got %v", policy, err)
+		}
+	}
+}
+
+func TestLayeredErrorPolicyCooldown(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	flaky := NewFunctional(
+		func(context.Context, string) ([]byte, error) { calls++; return nil, errBoom },
+		func(context.Context, string, []byte) error { return nil },
+		func(context.Context, string) error { return nil },
+	)
+	for _, policy := range []ReadPolicy{PolicyReadSerial, PolicyReadParallel} {
+		calls = 0
+		c := NewLayered(NewMemory(), flaky).
+			WithErrorPolicy(PolicyErrorCooldown).
+			WithCooldown(time.Hour).
+			WithReadPolicy(policy)
+		if _, err := c.Get(ctx, "k"); !errors.Is(err, errBoom) {
+			t.Fatalf("%s: expected the layer error, got %v", policy, err)
+		}
+		// the skipped layer may hold the key, so this is not a miss either
+		_, err := c.Get(ctx, "k")
+		if errors.Is(err, autocert.ErrCacheMiss) || !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("%s: expected ErrUnavailable for a skipped layer, got %v", policy, err)
+		}
+		if calls != 1 {
+			t.Fatalf("%s: expected the failing layer to be skipped, got %d calls", policy, calls)
+		}
+	}
+}
+
+func TestLayeredPromotesHits(t *testing.T) {
+	ctx := context.Background()
+	top, deep := NewMemory(), NewMemory()
+	deep.Put(ctx, "k", []byte("v"))
+	c := NewLayered(top, deep)
+	if data, err := c.Get(ctx, "k"); err != nil || string(data) != "v" {
+		t.Fatalf("expected a hit, got %q, %v", data, err)
+	}
+	if data, _ := top.Get(ctx, "k"); string(data) != "v" {
+		t.Fatal("expected the hit to be promoted to the top layer")
+	}
+	if _, err := c.Get(ctx, "missing"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected a miss, got %v", err)
+	}
+}
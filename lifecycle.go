@@ -0,0 +1,130 @@
+package certcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Lifecycle is implemented by caches holding a connection to a backend.
+// Wrapped caches (e.g. by Retry) are looked through by LayeredCache
+type Lifecycle interface {
+	// Ping checks that the backend can be reached
+	Ping(ctx context.Context) error
+	// Close releases the connection to the backend.
+	// The cache can't be used after it is closed
+	Close(ctx context.Context) error
+	// Ready reports whether the last Ping succeeded and the cache is not
+	// closed. It does not reach the backend, which makes it suitable for
+	// readiness probes (see PingEvery to keep it current)
+	Ready() bool
+}
+
+// ErrClosed is returned by Ping on a cache which was closed
+var ErrClosed = errors.New("cache is closed")
+
+// readiness keeps track of the outcome of the last Ping of a backend
+type readiness struct {
+	ready  atomic.Bool
+	closed atomic.Bool
+}
+
+// ping runs the check unless the backend was closed, and records its outcome
+func (r *readiness) ping(check func() error) error {
+	if r.closed.Load() {
+		return ErrClosed
+	}
+	err := check()
+	r.ready.Store(err == nil && !r.closed.Load())
+	return err
+}
+
+// close marks the backend closed, running release only the first time
+func (r *readiness) close(release func() error) error {
+	r.ready.Store(false)
+	if r.closed.Swap(true) {
+		return nil
+	}
+	return release()
+}
+
+// Ready reports whether the last Ping succeeded and the cache is not closed
+func (r *readiness) Ready() bool {
+	return r.ready.Load()
+}
+
+// PingEvery pings the cache right away and then at the given interval, which
+// keeps its Ready state current. The outcome of each ping is handed to fn, if
+// not nil. It blocks until the context is done
+func PingEvery(ctx context.Context, l Lifecycle, interval time.Duration, fn func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := l.Ping(ctx)
+		if fn != nil && ctx.Err() == nil {
+			fn(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeCaches closes the caches implementing Lifecycle, e.g. when a
+// LayeredCache can't be built out of them. Failures are only logged
+func closeCaches(ctx context.Context, caches []autocert.Cache) {
+	for _, cache := range caches {
+		if lc, ok := findCache[Lifecycle](cache); ok {
+			if err := lc.Close(ctx); err != nil {
+				log.Printf("[certcache] failed to close cache: %s", err)
+			}
+		}
+	}
+}
+
+// Ping pings every layer implementing Lifecycle, returning their errors joined
+func (c *LayeredCache) Ping(ctx context.Context) error {
+	var errs []error
+	for i, l := range c.layers {
+		if lc, ok := findCache[Lifecycle](l.cache); ok {
+			if err := lc.Ping(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("layer %d (%s): %w", i, l.name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close copies pending write-behind writes to the deeper layers (see Flush),
+// then closes every layer implementing Lifecycle, returning their errors joined
+func (c *LayeredCache) Close(ctx context.Context) error {
+	var errs []error
+	if err := c.Flush(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to flush write-behind writes: %w", err))
+	}
+	for i, l := range c.layers {
+		if lc, ok := findCache[Lifecycle](l.cache); ok {
+			if err := lc.Close(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("layer %d (%s): %w", i, l.name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Ready reports whether every layer implementing Lifecycle is ready
+func (c *LayeredCache) Ready() bool {
+	for _, l := range c.layers {
+		if lc, ok := findCache[Lifecycle](l.cache); ok && !lc.Ready() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package certcache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// fakeS3 answers HeadBucket, failing while fail is set
+type fakeS3 struct {
+	s3iface.S3API
+	fail atomic.Bool
+}
+
+func (f *fakeS3) HeadBucketWithContext(ctx aws.Context, in *s3.HeadBucketInput, _ ...request.Option) (*s3.HeadBucketOutput, error) {
+	if f.fail.Load() {
+		return nil, errBoom
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func TestLayeredLifecycle(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeS3{}
+	s, err := NewS3WithOptions(ctx, WithS3Client(f))
+	if err != nil {
+		t.Fatalf("NewS3WithOptions failed: %s", err)
+	}
+	// layers wrapped with options are looked through
+	c := NewLayered(NewMemory(), Layer(s, LayerReadOnly()))
+	if c.Ready() {
+		t.Fatal("expected the cache not to be ready before the first ping")
+	}
+	if err := c.Ping(ctx); err != nil || !c.Ready() {
+		t.Fatalf("expected the cache to be ready, got %v", err)
+	}
+
+	f.fail.Store(true)
+	if err := c.Ping(ctx); !errors.Is(err, errBoom) || c.Ready() {
+		t.Fatalf("expected the failed ping to be reported, got %v", err)
+	}
+	f.fail.Store(false)
+	c.Ping(ctx)
+
+	if err := c.Close(ctx); err != nil || c.Ready() {
+		t.Fatalf("expected the cache to be closed, got %v", err)
+	}
+	if err := c.Ping(ctx); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed after Close, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package certcache
+
+import (
+	"context"
+	"iter"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Lister is implemented by caches whose keys can be enumerated
+type Lister interface {
+	// List iterates over the keys starting with prefix. An error
+	// is yielded at most once, after which the iteration stops
+	List(ctx context.Context, prefix string) iter.Seq2[KeyInfo, error]
+	// Stat describes a single key. If there's no such key,
+	// Stat returns ErrCacheMiss
+	Stat(ctx context.Context, key string) (KeyInfo, error)
+}
+
+// KeyInfo describes a key held by a cache
+type KeyInfo struct {
+	Key  string
+	Size int64
+	// ModTime is the time the key was last written,
+	// zero if the backend does not record it
+	ModTime time.Time
+}
+
+// Keys collects the keys starting with prefix held by the cache
+func Keys(ctx context.Context, l Lister, prefix string) ([]string, error) {
+	var keys []string
+	for info, err := range l.List(ctx, prefix) {
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, info.Key)
+	}
+	return keys, nil
+}
+
+// List iterates, in sorted order, over the union of the keys held by the
+// readable layers implementing Lister. A key held by several layers is
+// described by the first layer Get would read it from
+func (c *LayeredCache) List(ctx context.Context, prefix string) iter.Seq2[KeyInfo, error] {
+	return func(yield func(KeyInfo, error) bool) {
+		found := map[string]KeyInfo{}
+		for i, l := range c.layers {
+			lister, ok := findCache[Lister](l.cache)
+			if !ok || !l.readable() {
+				continue
+			}
+			for info, err := range lister.List(ctx, prefix) {
+				if err != nil {
+					yield(KeyInfo{}, err)
+					return
+				}
+				if _, seen := found[info.Key]; !seen && c.routed(info.Key)[i] {
+					found[info.Key] = info
+				}
+			}
+		}
+		keys := make([]string, 0, len(found))
+		for key := range found {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !yield(found[key], nil) {
+				return
+			}
+		}
+	}
+}
+
+// Stat describes the key as held by the first layer implementing Lister
+// that Get would read it from
+func (c *LayeredCache) Stat(ctx context.Context, key string) (KeyInfo, error) {
+	for _, i := range c.readers(key) {
+		lister, ok := findCache[Lister](c.layers[i].cache)
+		if !ok {
+			continue
+		}
+		info, err := lister.Stat(ctx, key)
+		if err == autocert.ErrCacheMiss {
+			continue
+		}
+		return info, err
+	}
+	return KeyInfo{}, autocert.ErrCacheMiss
+}
+
+// listable reports whether any readable layer implements Lister
+func (c *LayeredCache) listable() bool {
+	for _, l := range c.layers {
+		if _, ok := findCache[Lister](l.cache); ok && l.readable() {
+			return true
+		}
+	}
+	return false
+}
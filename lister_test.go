@@ -0,0 +1,108 @@
+package certcache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestLayeredList(t *testing.T) {
+	ctx := context.Background()
+	top, deep := NewMemory(), NewDir(t.TempDir())
+	top.Put(ctx, "a.com", []byte("1"))
+	top.Put(ctx, "b.com", []byte("22"))
+	deep.Put(ctx, "b.com", []byte("333"))
+	deep.Put(ctx, "c.com", []byte("4444"))
+	c := NewLayered(top, deep)
+
+	var got []string
+	for info, err := range c.List(ctx, "") {
+		if err != nil {
+			t.Fatalf("List failed: %s", err)
+		}
+		got = append(got, fmt.Sprintf("%s:%d", info.Key, info.Size))
+	}
+	// b.com is described by the top layer, which Get reads first
+	if want := "a.com:1 b.com:2 c.com:4"; strings.Join(got, " ") != want {
+		t.Fatalf("expected %q, got %q", want, strings.Join(got, " "))
+	}
+
+	for range c.List(ctx, "") {
+		break // stopping early must not panic
+	}
+}
+
+func TestListPrefix(t *testing.T) {
+	ctx := context.Background()
+	for name, cache := range map[string]interface {
+		autocert.Cache
+		Lister
+	}{"memory": NewMemory(), "dir": NewDir(t.TempDir())} {
+		cache.Put(ctx, "acme_account+key", []byte("k"))
+		cache.Put(ctx, "a.com", []byte("1"))
+		keys, err := Keys(ctx, cache, "acme")
+		if err != nil || !slices.Equal(keys, []string{"acme_account+key"}) {
+			t.Fatalf("%s: expected the prefixed key only, got %v, %v", name, keys, err)
+		}
+	}
+}
+
+func TestStat(t *testing.T) {
+	ctx := context.Background()
+	deep := NewDir(t.TempDir())
+	deep.Put(ctx, "c.com", []byte("4444"))
+	c := NewLayered(NewMemory(), deep)
+
+	info, err := c.Stat(ctx, "c.com")
+	if err != nil || info.Size != 4 || info.ModTime.IsZero() {
+		t.Fatalf("expected c.com to be described, got %+v, %v", info, err)
+	}
+	if _, err := c.Stat(ctx, "missing"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected a miss, got %v", err)
+	}
+	if _, err := NewDir("/nonexistent/dir").Stat(ctx, "a.com"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected a miss from a missing directory, got %v", err)
+	}
+}
+
+func TestIsDirTempFile(t *testing.T) {
+	for name, temp := range map[string]bool{
+		"a.com":                     false,
+		"a.com123":                  true,
+		"a.com+rsa":                 false,
+		"a.com+rsa4":                true,
+		"a.com+token99":             true,
+		"acme_account+key":          false,
+		"acme_account+key0":         true,
+		"acme_account.key567":       true,
+		"tok+http-01":               false,
+		"tok+http-011234":           true,
+		"10.0.0.1":                  false,
+		"dynamodbstream_shard-0042": false,
+	} {
+		if got := isDirTempFile(name); got != temp {
+			t.Errorf("expected isDirTempFile(%q) to be %t", name, temp)
+		}
+	}
+}
+
+func TestDirListSkipsTempFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cache := NewDir(dir)
+	cache.Put(ctx, "a.com", []byte("1"))
+	// what autocert.DirCache leaves behind while a Put is in progress
+	if err := os.WriteFile(filepath.Join(dir, "a.com+rsa2931842"), []byte("2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := Keys(ctx, cache, "")
+	if err != nil || !slices.Equal(keys, []string{"a.com"}) {
+		t.Fatalf("expected temporary files to be skipped, got %v, %v", keys, err)
+	}
+}
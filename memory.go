@@ -0,0 +1,100 @@
+package certcache
+
+// Implementation of the autocert.Cache interface as per
+// https://godoc.org/golang.org/x/crypto/acme/autocert#Cache
+
+import (
+	"context"
+	"iter"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Memory is an in-process implementation of autocert.Cache.
+// It is safe for concurrent use and is typically the top layer of a LayeredCache.
+type Memory struct {
+	sync.RWMutex
+	data map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemory returns an empty in-memory certificate cache
+func NewMemory() *Memory {
+	return &Memory{data: make(map[string]memoryEntry)}
+}
+
+// Get returns a certificate data for the specified key.
+// If there's no such key, Get returns ErrCacheMiss.
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+	entry, ok := m.data[key]
+	if !ok {
+		return nil, autocert.ErrCacheMiss
+	}
+	return append([]byte(nil), entry.data...), nil
+}
+
+// Put stores the data in the cache under the specified key.
+// Underlying implementations may use any data storage format,
+// as long as the reverse operation, Get, results in the original data.
+func (m *Memory) Put(ctx context.Context, key string, data []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.data[key] = memoryEntry{data: append([]byte(nil), data...), modTime: time.Now()}
+	return nil
+}
+
+// Delete removes a certificate data from the cache under the specified key.
+// If there's no such key in the cache, Delete returns nil.
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+// List iterates, in sorted order, over the keys starting with prefix
+// as they were when List was called
+func (m *Memory) List(ctx context.Context, prefix string) iter.Seq2[KeyInfo, error] {
+	return func(yield func(KeyInfo, error) bool) {
+		m.RLock()
+		var infos []KeyInfo
+		for key, entry := range m.data {
+			if strings.HasPrefix(key, prefix) {
+				infos = append(infos, entry.info(key))
+			}
+		}
+		m.RUnlock()
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+		for _, info := range infos {
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Stat describes a single key.
+// If there's no such key, Stat returns ErrCacheMiss.
+func (m *Memory) Stat(ctx context.Context, key string) (KeyInfo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	entry, ok := m.data[key]
+	if !ok {
+		return KeyInfo{}, autocert.ErrCacheMiss
+	}
+	return entry.info(key), nil
+}
+
+func (e memoryEntry) info(key string) KeyInfo {
+	return KeyInfo{Key: key, Size: int64(len(e.data)), ModTime: e.modTime}
+}
@@ -5,27 +5,37 @@ package certcache
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"iter"
 	"log"
+	"regexp"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
-	"gopkg.in/mgo.v2/bson"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // MongoDB represents a MongoDB implementation of autocert.Cache
 type MongoDB struct {
 	conn     *mongo.Database
-	ctxt     context.Context
 	collname string
 	timeout  time.Duration
+	// ownsClient is set when the client was created by the
+	// constructor, in which case Close disconnects it
+	ownsClient bool
+	readiness
 }
 
+// doc is the stored form of a key. Its fields are exported since the
+// driver only encodes and decodes exported fields
 type doc struct {
-	_id  string `bson:"_id"`
-	data []byte `bson:"data"`
+	ID       string    `bson:"_id"`
+	Data     []byte    `bson:"data"`
+	Modified time.Time `bson:"modified,omitempty"`
 }
 
 const (
@@ -35,51 +45,91 @@ const (
 )
 
 // NewMongoDB returns a Mongo cache given a mongodb connection string
-// e.g. fmt.Sprintf("mongodb://%s:%s@%s/%s", username, password, host, db)
+// e.g. fmt.Sprintf("mongodb://%s:%s@%s/%s", username, password, host, db).
+// It exits the process if the server can't be reached, use
+// NewMongoDBWithOptions to handle that error instead
 func NewMongoDB(uri string) *MongoDB {
-	if uri == "" {
-		log.Fatalf("failed to connect to Mongo. Must specify connection string")
-	}
-	client, err := mongo.NewClient(options.Client().ApplyURI(uri))
+	mgo, err := NewMongoDBWithOptions(context.Background(), WithURI(uri))
 	if err != nil {
-		log.Fatalf("failed to create Mongo client: %s", err)
+		log.Fatalf("failed to connect to Mongo: %s", err)
+	}
+	return mgo
+}
+
+// NewMongoDBWithOptions returns a Mongo cache. Either a connection string
+// (WithURI) or a connected client (WithMongoClient) must be given. The
+// database and collection default to "certcache" and can be changed with
+// WithDatabase and WithName
+func NewMongoDBWithOptions(ctx context.Context, opts ...Option) (*MongoDB, error) {
+	o := newOptions(opts)
+	if o.database == "" {
+		o.database = defaultMongoCertCacheDBName
+	}
+	if o.name == "" {
+		o.name = defaultMongoCertCacheCollectionName
 	}
-	ctxt := context.Background()
-	if err = client.Connect(ctxt); err != nil {
-		log.Fatalf("failed to connect to Mongo server: %s", err)
+	if o.timeout == 0 {
+		o.timeout = defaultMongoCertCacheTimeout
 	}
-	if err = client.Ping(ctxt, readpref.Primary()); err != nil {
-		log.Fatalf("failed to reach Mongo server: %s", err)
+	client, owned := o.mongoClient, false
+	if client == nil {
+		owned = true
+		if o.uri == "" {
+			return nil, errors.New("must specify connection string or client")
+		}
+		clientOpts := options.Client().ApplyURI(o.uri)
+		if o.httpClient != nil {
+			clientOpts.SetHTTPClient(o.httpClient)
+		}
+		connectCtx, cancel := withTimeout(ctx, o.timeout)
+		defer cancel()
+		var err error
+		if client, err = mongo.Connect(connectCtx, clientOpts); err != nil {
+			return nil, fmt.Errorf("failed to create Mongo client: %w", mongoError(err))
+		}
+		if err = client.Ping(connectCtx, readpref.Primary()); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to reach Mongo server: %w", mongoError(err))
+		}
 	}
-	return &MongoDB{
-		ctxt:     ctxt,
-		conn:     client.Database(defaultMongoCertCacheDBName),
-		collname: defaultMongoCertCacheCollectionName,
-		timeout:  defaultMongoCertCacheTimeout,
+	mgo := &MongoDB{
+		conn:       client.Database(o.database),
+		collname:   o.name,
+		timeout:    o.timeout,
+		ownsClient: owned,
 	}
+	// the server was just reached when the client was created here
+	mgo.ready.Store(owned)
+	return mgo, nil
 }
 
 // Get returns a certificate data for the specified key.
 // If there's no such key, Get returns ErrCacheMiss.
 func (mgo *MongoDB) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, mgo.timeout)
+	defer cancel()
 	var d doc
 	err := mgo.conn.Collection(mgo.collname).FindOne(ctx, bson.M{"_id": key}).Decode(&d)
+	if err == mongo.ErrNoDocuments {
+		return nil, autocert.ErrCacheMiss
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get %s from Mongo: %s", key, err)
+		return nil, fmt.Errorf("failed to get %s from Mongo: %w", key, mongoError(err))
 	}
-	return d.data, nil
+	return d.Data, nil
 }
 
 // Put stores the data in the cache under the specified key.
 // Underlying implementations may use any data storage format,
 // as long as the reverse operation, Get, results in the original data.
+// An existing document is replaced, so that renewals overwrite the key
 func (mgo *MongoDB) Put(ctx context.Context, key string, data []byte) error {
-	doc := bson.D{
-		bson.DocElem{Name: "_id", Value: key},
-		bson.DocElem{Name: "data", Value: data},
-	}
-	if _, err := mgo.conn.Collection(mgo.collname).InsertOne(mgo.ctxt, doc); err != nil {
-		return fmt.Errorf("failed to store %s in Mongo: %s", key, err)
+	ctx, cancel := withTimeout(ctx, mgo.timeout)
+	defer cancel()
+	d := doc{ID: key, Data: data, Modified: time.Now()}
+	if _, err := mgo.conn.Collection(mgo.collname).ReplaceOne(ctx, bson.M{"_id": key}, d,
+		options.Replace().SetUpsert(true)); err != nil {
+		return fmt.Errorf("failed to store %s in Mongo: %w", key, mongoError(err))
 	}
 	return nil
 }
@@ -87,9 +137,85 @@ func (mgo *MongoDB) Put(ctx context.Context, key string, data []byte) error {
 // Delete removes a certificate data from the cache under the specified key.
 // If there's no such key in the cache, Delete returns nil.
 func (mgo *MongoDB) Delete(ctx context.Context, key string) error {
-	doc := bson.D{bson.DocElem{Name: "_id", Value: key}}
-	if _, err := mgo.conn.Collection(mgo.collname).DeleteOne(ctx, doc); err != nil {
-		return fmt.Errorf("failed to delete %s from Mongo: %s", key, err)
+	ctx, cancel := withTimeout(ctx, mgo.timeout)
+	defer cancel()
+	if _, err := mgo.conn.Collection(mgo.collname).DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+		return fmt.Errorf("failed to delete %s from Mongo: %w", key, mongoError(err))
 	}
 	return nil
 }
+
+// List iterates over the keys starting with prefix, in lexicographic order.
+// ModTime is zero for documents written before it was recorded
+func (mgo *MongoDB) List(ctx context.Context, prefix string) iter.Seq2[KeyInfo, error] {
+	return func(yield func(KeyInfo, error) bool) {
+		filter := bson.M{}
+		if prefix != "" {
+			filter["_id"] = bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}
+		}
+		cur, err := mgo.conn.Collection(mgo.collname).Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			yield(KeyInfo{}, fmt.Errorf("failed to list keys in Mongo: %w", mongoError(err)))
+			return
+		}
+		defer cur.Close(context.WithoutCancel(ctx))
+		for cur.Next(ctx) {
+			var d doc
+			if err := cur.Decode(&d); err != nil {
+				yield(KeyInfo{}, fmt.Errorf("failed to decode Mongo document: %w", classify(ErrCorrupt, err)))
+				return
+			}
+			if !yield(d.info(), nil) {
+				return
+			}
+		}
+		if err := cur.Err(); err != nil {
+			yield(KeyInfo{}, fmt.Errorf("failed to list keys in Mongo: %w", mongoError(err)))
+		}
+	}
+}
+
+// Stat describes a single key.
+// If there's no such key, Stat returns ErrCacheMiss.
+func (mgo *MongoDB) Stat(ctx context.Context, key string) (KeyInfo, error) {
+	ctx, cancel := withTimeout(ctx, mgo.timeout)
+	defer cancel()
+	var d doc
+	err := mgo.conn.Collection(mgo.collname).FindOne(ctx, bson.M{"_id": key}).Decode(&d)
+	if err == mongo.ErrNoDocuments {
+		return KeyInfo{}, autocert.ErrCacheMiss
+	}
+	if err != nil {
+		return KeyInfo{}, fmt.Errorf("failed to get %s from Mongo: %w", key, mongoError(err))
+	}
+	return d.info(), nil
+}
+
+func (d doc) info() KeyInfo {
+	return KeyInfo{Key: d.ID, Size: int64(len(d.Data)), ModTime: d.Modified}
+}
+
+// Ping checks that the primary can be reached
+func (mgo *MongoDB) Ping(ctx context.Context) error {
+	return mgo.ping(func() error {
+		ctx, cancel := withTimeout(ctx, mgo.timeout)
+		defer cancel()
+		if err := mgo.conn.Client().Ping(ctx, readpref.Primary()); err != nil {
+			return fmt.Errorf("failed to reach Mongo server: %w", mongoError(err))
+		}
+		return nil
+	})
+}
+
+// Close disconnects the client, unless it was given with WithMongoClient
+func (mgo *MongoDB) Close(ctx context.Context) error {
+	return mgo.close(func() error {
+		if !mgo.ownsClient {
+			return nil
+		}
+		if err := mgo.conn.Client().Disconnect(ctx); err != nil {
+			return fmt.Errorf("failed to disconnect from Mongo: %w", mongoError(err))
+		}
+		return nil
+	})
+}
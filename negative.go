@@ -0,0 +1,150 @@
+package certcache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// NegativeCacheOptions configures how long a NegativeCache remembers misses
+type NegativeCacheOptions struct {
+	// TTL is how long a miss is remembered. Defaults to 1 minute
+	TTL time.Duration
+	// MaxEntries bounds the number of misses remembered at once, since the
+	// keys looked up depend on the names clients ask for. Once it is
+	// reached, the oldest miss is forgotten. Defaults to 10000
+	MaxEntries int
+}
+
+const (
+	defaultNegativeCacheTTL        = 1 * time.Minute
+	defaultNegativeCacheMaxEntries = 10000
+)
+
+// NegativeCache is an autocert.Cache which remembers the keys another cache
+// missed, answering ErrCacheMiss for them without calling it until they
+// expire. autocert looks up several keys per name, most of which miss,
+// and each of those lookups costs a request to a remote backend.
+// Writes made through the NegativeCache are seen right away; writes made
+// to the wrapped cache by other means are seen once the miss expires
+type NegativeCache struct {
+	cache autocert.Cache
+	opts  NegativeCacheOptions
+
+	mu      sync.Mutex
+	misses  map[string]*list.Element // key to its element in order
+	order   *list.List               // of *negativeEntry, oldest first
+	version uint64                   // incremented by every write
+}
+
+// negativeEntry is a remembered miss. Since every miss lives for the same
+// TTL, the order in which they are remembered is also their expiry order
+type negativeEntry struct {
+	key    string
+	expiry time.Time
+}
+
+// NewNegativeCache wraps the cache so that its misses are remembered
+func NewNegativeCache(cache autocert.Cache, opts NegativeCacheOptions) *NegativeCache {
+	if opts.TTL <= 0 {
+		opts.TTL = defaultNegativeCacheTTL
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = defaultNegativeCacheMaxEntries
+	}
+	return &NegativeCache{
+		cache:  cache,
+		opts:   opts,
+		misses: make(map[string]*list.Element),
+		order:  list.New(),
+	}
+}
+
+// Get returns a certificate data for the specified key.
+// If there's no such key, Get returns ErrCacheMiss.
+func (n *NegativeCache) Get(ctx context.Context, key string) ([]byte, error) {
+	n.mu.Lock()
+	if elem, ok := n.misses[key]; ok {
+		if time.Now().Before(elem.Value.(*negativeEntry).expiry) {
+			n.mu.Unlock()
+			return nil, autocert.ErrCacheMiss
+		}
+		n.forget(elem)
+	}
+	version := n.version
+	n.mu.Unlock()
+
+	data, err := n.cache.Get(ctx, key)
+	if errors.Is(err, autocert.ErrCacheMiss) {
+		n.remember(key, version)
+	}
+	return data, err
+}
+
+// Put stores the data in the cache under the specified key.
+// Underlying implementations may use any data storage format,
+// as long as the reverse operation, Get, results in the original data.
+func (n *NegativeCache) Put(ctx context.Context, key string, data []byte) error {
+	err := n.cache.Put(ctx, key, data)
+	n.Forget(key)
+	return err
+}
+
+// Delete removes a certificate data from the cache under the specified key.
+// If there's no such key in the cache, Delete returns nil.
+func (n *NegativeCache) Delete(ctx context.Context, key string) error {
+	err := n.cache.Delete(ctx, key)
+	n.Forget(key)
+	return err
+}
+
+// Forget drops the remembered miss of the key, if any, e.g. when
+// the key was written to the wrapped cache by another process
+func (n *NegativeCache) Forget(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if elem, ok := n.misses[key]; ok {
+		n.forget(elem)
+	}
+	// a Get in flight may have missed before the write landed
+	n.version++
+}
+
+// Unwrap returns the wrapped cache
+func (n *NegativeCache) Unwrap() autocert.Cache {
+	return n.cache
+}
+
+// remember records a miss of the key, unless a write was made since the
+// lookup started. The oldest miss is forgotten to make room for it if needed
+func (n *NegativeCache) remember(key string, version uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.version != version {
+		return
+	}
+	if elem, ok := n.misses[key]; ok {
+		// a concurrent Get of the key missed as well
+		n.forget(elem)
+	}
+	now := time.Now()
+	// expired misses are at the front, drop them while we are at it
+	for front := n.order.Front(); front != nil && !now.Before(front.Value.(*negativeEntry).expiry); front = n.order.Front() {
+		n.forget(front)
+	}
+	if len(n.misses) >= n.opts.MaxEntries {
+		n.forget(n.order.Front())
+	}
+	entry := &negativeEntry{key: key, expiry: now.Add(n.opts.TTL)}
+	n.misses[key] = n.order.PushBack(entry)
+}
+
+// forget drops a remembered miss. Must be called with mu held
+func (n *NegativeCache) forget(elem *list.Element) {
+	delete(n.misses, elem.Value.(*negativeEntry).key)
+	n.order.Remove(elem)
+}
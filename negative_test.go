@@ -0,0 +1,71 @@
+package certcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// countingCache counts the Gets which reach the memory cache
+func countingCache(m *Memory, calls *int) *Functional {
+	return NewFunctional(func(ctx context.Context, key string) ([]byte, error) {
+		*calls++
+		return m.Get(ctx, key)
+	}, m.Put, m.Delete)
+}
+
+func TestNegativeCacheRemembersMisses(t *testing.T) {
+	ctx := context.Background()
+	var calls int
+	n := NewNegativeCache(countingCache(NewMemory(), &calls), NegativeCacheOptions{TTL: time.Hour})
+	for i := 0; i < 5; i++ {
+		if _, err := n.Get(ctx, "a.com"); err != autocert.ErrCacheMiss {
+			t.Fatalf("expected a miss, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected a single lookup, got %d", calls)
+	}
+	// writes through the cache are seen right away
+	n.Put(ctx, "a.com", []byte("cert"))
+	if data, err := n.Get(ctx, "a.com"); err != nil || string(data) != "cert" {
+		t.Fatalf("expected the written data, got %q, %v", data, err)
+	}
+}
+
+func TestNegativeCacheExpiresMisses(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	var calls int
+	n := NewNegativeCache(countingCache(m, &calls), NegativeCacheOptions{TTL: 10 * time.Millisecond})
+	n.Get(ctx, "a.com")
+	// written by another process
+	m.Put(ctx, "a.com", []byte("cert"))
+	time.Sleep(20 * time.Millisecond)
+	if data, err := n.Get(ctx, "a.com"); err != nil || string(data) != "cert" {
+		t.Fatalf("expected the miss to expire, got %q, %v", data, err)
+	}
+}
+
+func TestNegativeCacheEvictsOldestMiss(t *testing.T) {
+	ctx := context.Background()
+	var calls int
+	n := NewNegativeCache(countingCache(NewMemory(), &calls), NegativeCacheOptions{TTL: time.Hour, MaxEntries: 2})
+	for _, key := range []string{"a.com", "b.com", "c.com"} {
+		n.Get(ctx, key)
+	}
+	if len(n.misses) != 2 || n.order.Len() != 2 {
+		t.Fatalf("expected 2 misses to be remembered, got %d", len(n.misses))
+	}
+	calls = 0
+	n.Get(ctx, "c.com")
+	if calls != 0 {
+		t.Fatal("expected the newest miss to be remembered")
+	}
+	n.Get(ctx, "a.com")
+	if calls != 1 {
+		t.Fatal("expected the oldest miss to be forgotten")
+	}
+}
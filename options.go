@@ -0,0 +1,156 @@
+package certcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Option configures a backend built by one of the New<Backend>WithOptions
+// constructors. Options which don't apply to a backend are ignored
+type Option func(*backendOptions)
+
+type backendOptions struct {
+	name        string
+	database    string
+	region      string
+	timeout     time.Duration
+	httpClient  *http.Client
+	credentials *credentials.Credentials
+	credsFile   string
+	projectID   string
+	uri         string
+	// lenient makes a failure to configure the AWS session non fatal, as
+	// in the original constructors which predate the options ones
+	lenient bool
+
+	s3Client        s3iface.S3API
+	dynamoDBClient  dynamodbiface.DynamoDBAPI
+	mongoClient     *mongo.Client
+	firestoreClient *firestore.Client
+}
+
+func newOptions(opts []Option) *backendOptions {
+	o := &backendOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithName sets the name of the S3 bucket, DynamoDB table,
+// MongoDB collection or Firestore collection holding the certificates
+func WithName(name string) Option {
+	return func(o *backendOptions) { o.name = name }
+}
+
+// WithDatabase sets the name of the MongoDB database
+func WithDatabase(database string) Option {
+	return func(o *backendOptions) { o.database = database }
+}
+
+// WithRegion sets the AWS region of an S3 or DynamoDB backend
+func WithRegion(region string) Option {
+	return func(o *backendOptions) { o.region = region }
+}
+
+// WithTimeout sets the deadline applied to every operation of the backend
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *backendOptions) { o.timeout = timeout }
+}
+
+// WithHTTPClient sets the HTTP client used by an S3 or DynamoDB backend when
+// the constructor builds the service client itself. It is ignored by Firestore,
+// whose client speaks gRPC; give it a client with WithFirestoreClient instead
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *backendOptions) { o.httpClient = client }
+}
+
+// WithAWSCredentials sets the credentials of an S3 or DynamoDB backend.
+// When not set, the default AWS credential chain is used
+func WithAWSCredentials(creds *credentials.Credentials) Option {
+	return func(o *backendOptions) { o.credentials = creds }
+}
+
+// WithCredentialsFile sets the path to the Google credentials of a Firestore backend
+func WithCredentialsFile(path string) Option {
+	return func(o *backendOptions) { o.credsFile = path }
+}
+
+// WithProjectID sets the Google Cloud project of a Firestore backend
+func WithProjectID(projectID string) Option {
+	return func(o *backendOptions) { o.projectID = projectID }
+}
+
+// WithURI sets the connection string of a MongoDB backend
+func WithURI(uri string) Option {
+	return func(o *backendOptions) { o.uri = uri }
+}
+
+// WithS3Client makes an S3 backend use the given client
+func WithS3Client(client s3iface.S3API) Option {
+	return func(o *backendOptions) { o.s3Client = client }
+}
+
+// WithDynamoDBClient makes a DynamoDB backend use the given client
+func WithDynamoDBClient(client dynamodbiface.DynamoDBAPI) Option {
+	return func(o *backendOptions) { o.dynamoDBClient = client }
+}
+
+// WithMongoClient makes a MongoDB backend use the given (connected) client
+func WithMongoClient(client *mongo.Client) Option {
+	return func(o *backendOptions) { o.mongoClient = client }
+}
+
+// WithFirestoreClient makes a Firestore backend use the given client
+func WithFirestoreClient(client *firestore.Client) Option {
+	return func(o *backendOptions) { o.firestoreClient = client }
+}
+
+// withLenientSession makes the backend fall back to session.New if the AWS
+// session can't be configured, which logs the error and fails every request
+func withLenientSession() Option {
+	return func(o *backendOptions) { o.lenient = true }
+}
+
+// newAWSSession builds an AWS session from the options
+func newAWSSession(o *backendOptions, defaultRegion string) (*session.Session, error) {
+	region := o.region
+	if region == "" {
+		region = defaultRegion
+	}
+	httpClient := o.httpClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: o.timeout}
+	}
+	cfg := &aws.Config{
+		Credentials: o.credentials,
+		Region:      aws.String(region),
+		HTTPClient:  httpClient,
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		if o.lenient {
+			return session.New(cfg), nil
+		}
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	return sess, nil
+}
+
+// withTimeout bounds the context with the timeout, if any
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,20 @@
+package certcache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewS3AndDynamoDBSurviveSessionErrors(t *testing.T) {
+	// an invalid setting makes the AWS session fail to configure
+	t.Setenv("AWS_STS_REGIONAL_ENDPOINTS", "bogus")
+	if _, err := NewS3WithOptions(context.Background()); err == nil {
+		t.Fatal("expected NewS3WithOptions to report the session error")
+	}
+	if NewS3(nil, "bucket", "us-east-1") == nil {
+		t.Fatal("expected NewS3 to build a cache anyway")
+	}
+	if NewDynamoDB(nil, "us-east-1", "table") == nil {
+		t.Fatal("expected NewDynamoDB to build a cache anyway")
+	}
+}
@@ -0,0 +1,195 @@
+package certcache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	quorumPolicyPrefix = "QUORUM_"
+)
+
+// quorum returns the number of acknowledgements required
+// by a write policy built with PolicyWriteQuorum
+func (wp WritePolicy) quorum() (int, bool) {
+	if !strings.HasPrefix(string(wp), quorumPolicyPrefix) {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(string(wp), quorumPolicyPrefix))
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// WithReadQuorum makes Get query every layer at once and only return data
+// which at least n layers agree on. A key missing from n layers is a miss.
+// This takes precedence over the read policy. Zero disables read quorums
+func (c *LayeredCache) WithReadQuorum(n int) *LayeredCache {
+	c.readQuorum = n
+	return c
+}
+
+// layerResult is the outcome of an operation on the layer at index
+type layerResult struct {
+	index int
+	data  []byte
+	err   error
+}
+
+// writeQuorum applies the operation to all layers at once and returns as
+// soon as n of them succeed. Layers still writing at that point carry on in
+// the background, and any failures which did not prevent the quorum are logged.
+// If the quorum can't be reached, the error wraps a *LayerError. Under
+// WithTransactions, layers whose previous value can't be read are not
+// written to and count as failed
+func (c *LayeredCache) writeQuorum(ctx context.Context, n int, op Op, key string, data []byte) error {
+	replicas := c.writers(key)
+	if n > len(replicas) {
+		return fmt.Errorf("write quorum of %d is larger than the number of writable layers (%d)", n, len(replicas))
+	}
+	var acked, failed []layerResult
+	snapshots := make([]snapshot, len(c.layers))
+	targets := replicas
+	if c.transactional {
+		// a replica whose previous value can't be read is not written to,
+		// since it could not be rolled back, and counts as a failed one
+		targets = nil
+		for _, i := range replicas {
+			snap, err := c.snapshot(ctx, i, key)
+			if err != nil {
+				failed = append(failed, layerResult{index: i, err: err})
+				continue
+			}
+			snapshots[i] = snap
+			targets = append(targets, i)
+		}
+	}
+	fail := func(done []snapshot) error {
+		sort.Slice(failed, func(i, j int) bool { return failed[i].index < failed[j].index })
+		failures := make([]LayerFailure, 0, len(failed))
+		for _, r := range failed {
+			failures = append(failures, c.failure(r.index, r.err))
+		}
+		return fmt.Errorf("write quorum of %d not reached (%d acknowledged): %w",
+			n, len(acked), c.abort(ctx, op, key, failures, done))
+	}
+	if len(failed) > len(replicas)-n {
+		return fail(nil)
+	}
+
+	// the write must outlive the call if we return on quorum
+	writeCtx := context.WithoutCancel(ctx)
+	results := make(chan layerResult, len(targets))
+	for _, i := range targets {
+		go func() {
+			results <- layerResult{index: i, err: c.layers[i].apply(writeCtx, op, key, data)}
+		}()
+	}
+
+	for answered := 1; answered <= len(targets); answered++ {
+		r := <-results
+		if r.err == nil {
+			acked = append(acked, r)
+		} else {
+			failed = append(failed, r)
+		}
+		if len(acked) == n {
+			go logQuorumFailures(op, key, failed, results, len(targets)-answered)
+			return nil
+		}
+		if len(failed) > len(replicas)-n {
+			var done []snapshot
+			if c.transactional {
+				// wait for every layer so that all successful writes are rolled back
+				for ; answered < len(targets); answered++ {
+					if r := <-results; r.err == nil {
+						acked = append(acked, r)
+					} else {
+						failed = append(failed, r)
+					}
+				}
+				for _, r := range acked {
+					done = append(done, snapshots[r.index])
+				}
+			}
+			return fail(done)
+		}
+	}
+	return nil
+}
+
+// logQuorumFailures logs the layers which failed a write that reached its
+// quorum, including those which had not answered yet
+func logQuorumFailures(op Op, key string, failed []layerResult, results <-chan layerResult, pending int) {
+	for ; pending > 0; pending-- {
+		if r := <-results; r.err != nil {
+			failed = append(failed, r)
+		}
+	}
+	for _, r := range failed {
+		log.Printf("[certcache] %s of %s reached quorum but failed on layer %d: %s", op, key, r.index, r.err)
+	}
+}
+
+// failuresError wraps the errors of the failed layers, shallowest first
+func failuresError(failed []layerResult) error {
+	sort.Slice(failed, func(i, j int) bool { return failed[i].index < failed[j].index })
+	parts := make([]string, 0, len(failed))
+	args := make([]any, 0, 2*len(failed))
+	for _, r := range failed {
+		parts = append(parts, "layer %d: %w")
+		args = append(args, r.index, r.err)
+	}
+	return fmt.Errorf(strings.Join(parts, "; "), args...)
+}
+
+// getQuorum reads from all layers at once and returns as soon as
+// the read quorum agrees on the data (or on the key being missing)
+func (c *LayeredCache) getQuorum(ctx context.Context, key string) ([]byte, error) {
+	n := c.readQuorum
+	replicas := c.readers(key)
+	if n > len(replicas) {
+		return nil, fmt.Errorf("read quorum of %d is larger than the number of readable layers (%d)", n, len(replicas))
+	}
+	lookupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan layerResult, len(replicas))
+	for _, i := range replicas {
+		go func() {
+			data, err := c.layers[i].get(lookupCtx, key)
+			results <- layerResult{index: i, data: data, err: err}
+		}()
+	}
+
+	votes := map[string]int{}
+	misses := 0
+	var failed []layerResult
+	for range replicas {
+		r := <-results
+		switch r.err {
+		case nil:
+			if votes[string(r.data)]++; votes[string(r.data)] == n {
+				return r.data, nil
+			}
+		case autocert.ErrCacheMiss:
+			if misses++; misses == n {
+				return nil, autocert.ErrCacheMiss
+			}
+		default:
+			failed = append(failed, r)
+		}
+	}
+	err := fmt.Errorf("read quorum of %d not reached for %s: %d distinct values, %d misses", n, key, len(votes), misses)
+	if len(failed) > 0 {
+		err = fmt.Errorf("%w; %w", err, failuresError(failed))
+	}
+	return nil, err
+}
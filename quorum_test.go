@@ -0,0 +1,68 @@
+package certcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// unreadableCache fails every Get but accepts writes
+func unreadableCache(m *Memory) *Functional {
+	return NewFunctional(func(context.Context, string) ([]byte, error) {
+		return nil, errBoom
+	}, m.Put, m.Delete)
+}
+
+func TestWriteQuorum(t *testing.T) {
+	ctx := context.Background()
+	a, b := NewMemory(), NewMemory()
+	c := NewLayeredWithPolicy(PolicyWriteQuorum(2), a, failingCache(), b)
+	if err := c.Put(ctx, "k", []byte("v")); err != nil {
+		t.Fatalf("expected the quorum to be reached, got %v", err)
+	}
+	c = NewLayeredWithPolicy(PolicyWriteQuorum(3), a, failingCache(), b)
+	var lerr *LayerError
+	if err := c.Put(ctx, "k", []byte("v")); !errors.As(err, &lerr) || len(lerr.Failures) != 1 {
+		t.Fatalf("expected a LayerError naming the failed layer, got %v", err)
+	}
+}
+
+func TestTransactionalWriteQuorumToleratesFailedSnapshots(t *testing.T) {
+	ctx := context.Background()
+	a, b, unread := NewMemory(), NewMemory(), NewMemory()
+	c := NewLayeredWithPolicy(PolicyWriteQuorum(2), a, unreadableCache(unread), b).WithTransactions(true)
+	if err := c.Put(ctx, "k", []byte("v")); err != nil {
+		t.Fatalf("expected a single unreadable layer not to abort the write, got %v", err)
+	}
+	for name, m := range map[string]*Memory{"first": a, "last": b} {
+		if data, _ := m.Get(ctx, "k"); string(data) != "v" {
+			t.Fatalf("expected the %s layer to be written, got %q", name, data)
+		}
+	}
+	if _, err := unread.Get(ctx, "k"); err != autocert.ErrCacheMiss {
+		t.Fatal("expected the layer which could not be snapshotted not to be written")
+	}
+}
+
+func TestTransactionalWriteQuorumRollsBack(t *testing.T) {
+	ctx := context.Background()
+	a, b := NewMemory(), NewMemory()
+	a.Put(ctx, "k", []byte("old"))
+	unwritable := NewFunctional(func(context.Context, string) ([]byte, error) {
+		return nil, autocert.ErrCacheMiss
+	}, func(context.Context, string, []byte) error { return errBoom }, nil)
+	c := NewLayeredWithPolicy(PolicyWriteQuorum(3), a, unreadableCache(NewMemory()), unwritable, b).
+		WithTransactions(true)
+	var lerr *LayerError
+	if err := c.Put(ctx, "k", []byte("new")); !errors.As(err, &lerr) || !lerr.RolledBack {
+		t.Fatalf("expected a rolled back LayerError, got %v", err)
+	}
+	if data, _ := a.Get(ctx, "k"); string(data) != "old" {
+		t.Fatalf("expected the previous value to be restored, got %q", data)
+	}
+	if _, err := b.Get(ctx, "k"); err != autocert.ErrCacheMiss {
+		t.Fatal("expected the new key to be deleted again")
+	}
+}
@@ -0,0 +1,159 @@
+package certcache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// RedisBroadcaster wraps an autocert.Cache and publishes the key of every
+// Put and Delete on a Redis channel. This lets processes which share a backend
+// with no change feed of its own (e.g. S3) evict their local copies of a key
+type RedisBroadcaster struct {
+	cache   autocert.Cache
+	client  redis.UniversalClient
+	channel string
+}
+
+// RedisSubscriber receives the invalidations published by a RedisBroadcaster
+type RedisSubscriber struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+type redisMessage struct {
+	Key  string    `json:"key"`
+	Op   Op        `json:"op"`
+	Time time.Time `json:"time"`
+}
+
+const (
+	defaultRedisInvalidationChannel = "certcache"
+)
+
+// NewRedisBroadcaster returns a cache which publishes invalidations for the
+// wrapped cache on the given Redis channel
+func NewRedisBroadcaster(cache autocert.Cache, client redis.UniversalClient, channel string) *RedisBroadcaster {
+	if channel == "" {
+		channel = defaultRedisInvalidationChannel
+	}
+	return &RedisBroadcaster{
+		cache:   cache,
+		client:  client,
+		channel: channel,
+	}
+}
+
+// NewRedisSubscriber returns a subscriber for the invalidations
+// published on the given Redis channel
+func NewRedisSubscriber(client redis.UniversalClient, channel string) *RedisSubscriber {
+	if channel == "" {
+		channel = defaultRedisInvalidationChannel
+	}
+	return &RedisSubscriber{
+		client:  client,
+		channel: channel,
+	}
+}
+
+// Get returns a certificate data for the specified key.
+// If there's no such key, Get returns ErrCacheMiss.
+func (r *RedisBroadcaster) Get(ctx context.Context, key string) ([]byte, error) {
+	return r.cache.Get(ctx, key)
+}
+
+// Put stores the data in the cache under the specified key.
+// Underlying implementations may use any data storage format,
+// as long as the reverse operation, Get, results in the original data.
+// A failure to publish the invalidation is logged, since the write itself
+// succeeded and other processes only keep serving their copy until it expires
+func (r *RedisBroadcaster) Put(ctx context.Context, key string, data []byte) error {
+	if err := r.cache.Put(ctx, key, data); err != nil {
+		return err
+	}
+	r.publish(ctx, key, OpPut)
+	return nil
+}
+
+// Delete removes a certificate data from the cache under the specified key.
+// If there's no such key in the cache, Delete returns nil.
+// A failure to publish the invalidation is logged, as with Put
+func (r *RedisBroadcaster) Delete(ctx context.Context, key string) error {
+	if err := r.cache.Delete(ctx, key); err != nil {
+		return err
+	}
+	r.publish(ctx, key, OpDelete)
+	return nil
+}
+
+// Unwrap returns the wrapped cache
+func (r *RedisBroadcaster) Unwrap() autocert.Cache {
+	return r.cache
+}
+
+func (r *RedisBroadcaster) publish(ctx context.Context, key string, op Op) {
+	msg, err := json.Marshal(redisMessage{Key: key, Op: op, Time: time.Now()})
+	if err == nil {
+		err = r.client.Publish(ctx, r.channel, msg).Err()
+	}
+	if err != nil {
+		log.Printf("[certcache] failed to publish invalidation for %s on redis channel %s: %s", key, r.channel, err)
+	}
+}
+
+// Watch calls fn for every invalidation published on the channel. Malformed
+// messages are skipped. Watch blocks until the context is done (in which case
+// it returns nil) or the subscription fails. This makes RedisSubscriber a Watcher
+func (r *RedisSubscriber) Watch(ctx context.Context, fn func(Event)) error {
+	pubsub := r.client.Subscribe(ctx, r.channel)
+	defer pubsub.Close()
+
+	// wait for the subscription to be confirmed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("failed to subscribe to redis channel %s: %w", r.channel, err)
+	}
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-ch:
+			if !ok {
+				return fmt.Errorf("subscription to redis channel %s closed", r.channel)
+			}
+			if e, ok := parseRedisMessage(m.Payload); ok {
+				fn(e)
+			}
+		}
+	}
+}
+
+// parseRedisMessage decodes an invalidation, reporting whether it is well formed
+func parseRedisMessage(payload string) (Event, bool) {
+	var msg redisMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil || msg.Key == "" {
+		return Event{}, false
+	}
+	return Event{Key: msg.Key, Op: msg.Op, Time: msg.Time}, true
+}
+
+// Evict removes every invalidated key from the given caches, which are
+// typically the in-memory layers of this process. Evict blocks until the
+// context is done (in which case it returns nil) or the subscription fails
+func (r *RedisSubscriber) Evict(ctx context.Context, caches ...autocert.Cache) error {
+	return r.Watch(ctx, func(e Event) {
+		for _, c := range caches {
+			// eviction is best effort, a stale copy
+			// is preferable to not serving at all
+			c.Delete(ctx, e.Key)
+		}
+	})
+}
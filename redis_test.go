@@ -0,0 +1,96 @@
+package certcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis records the messages published on it, failing while fail is set
+type fakeRedis struct {
+	redis.UniversalClient
+	published []string
+	fail      bool
+}
+
+func (f *fakeRedis) Publish(ctx context.Context, channel string, message interface{}) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx)
+	if f.fail {
+		cmd.SetErr(errors.New("connection refused"))
+		return cmd
+	}
+	f.published = append(f.published, string(message.([]byte)))
+	cmd.SetVal(1)
+	return cmd
+}
+
+func TestRedisBroadcasterPublishesWrites(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeRedis{}
+	r := NewRedisBroadcaster(NewMemory(), client, "")
+	r.Put(ctx, "a.com", []byte("cert"))
+	r.Delete(ctx, "a.com")
+	if len(client.published) != 2 {
+		t.Fatalf("expected 2 invalidations, got %v", client.published)
+	}
+	for i, op := range []Op{OpPut, OpDelete} {
+		e, ok := parseRedisMessage(client.published[i])
+		if !ok || e.Key != "a.com" || e.Op != op || time.Since(e.Time) > time.Minute {
+			t.Fatalf("expected a %s of a.com, got %+v", op, e)
+		}
+	}
+}
+
+func TestRedisBroadcasterIgnoresPublishFailures(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	r := NewRedisBroadcaster(m, &fakeRedis{fail: true}, "")
+	if err := r.Put(ctx, "a.com", []byte("cert")); err != nil {
+		t.Fatalf("expected the write to succeed, got %v", err)
+	}
+	if data, _ := m.Get(ctx, "a.com"); string(data) != "cert" {
+		t.Fatal("expected the data to be written")
+	}
+	if err := r.Delete(ctx, "a.com"); err != nil {
+		t.Fatalf("expected the delete to succeed, got %v", err)
+	}
+
+	// a failed write is not published
+	client := &fakeRedis{}
+	if err := NewRedisBroadcaster(failingCache(), client, "").Put(ctx, "a.com", nil); !errors.Is(err, errBoom) {
+		t.Fatalf("expected the write error, got %v", err)
+	}
+	if len(client.published) != 0 {
+		t.Fatal("expected no invalidation for a failed write")
+	}
+}
+
+func TestRedisBroadcasterIsLookedThrough(t *testing.T) {
+	ctx := context.Background()
+	backend := &closableMemory{Memory: NewMemory()}
+	c := NewLayered(NewMemory(), NewRedisBroadcaster(backend, &fakeRedis{}, ""))
+	if err := c.Close(ctx); err != nil || !backend.closed.Load() {
+		t.Fatalf("expected the wrapped cache to be closed, got %v", err)
+	}
+
+	// changes reported by the wrapped backend are attributed to the layer
+	top, deep := NewMemory(), NewMemory()
+	top.Put(ctx, "a.com", []byte("old"))
+	bus := NewBus()
+	defer NewLayered(top, NewRedisBroadcaster(deep, &fakeRedis{}, "")).Subscribe(bus)()
+	bus.Publish(Event{Key: "a.com", Op: OpPut, Source: deep})
+	if _, err := top.Get(ctx, "a.com"); err == nil {
+		t.Fatal("expected the key to be evicted from the layer above")
+	}
+}
+
+func TestParseRedisMessage(t *testing.T) {
+	for _, payload := range []string{"", "not json", `{"op":"PUT"}`} {
+		if _, ok := parseRedisMessage(payload); ok {
+			t.Fatalf("expected %q to be skipped", payload)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package certcache
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestOpenDir(t *testing.T) {
+	ctx := context.Background()
+	for rawURL, want := range map[string]string{
+		"dir:///var/cache/certs": "/var/cache/certs",
+		"dir://certs":            "certs",
+		"dir://var/cache/certs":  "var/cache/certs",
+	} {
+		c, err := Open(ctx, rawURL)
+		if err != nil {
+			t.Fatalf("%s: Open failed: %s", rawURL, err)
+		}
+		if d, ok := c.(*Dir); !ok || string(d.DirCache) != want {
+			t.Fatalf("%s: expected a Dir rooted at %s, got %#v", rawURL, want, c)
+		}
+	}
+	if _, err := Open(ctx, "dir://"); err == nil {
+		t.Fatal("expected a dir URL without a path to fail")
+	}
+}
+
+func TestOpenFirestore(t *testing.T) {
+	ctx := context.Background()
+	// the emulator client is built without credentials or a connection
+	t.Setenv("FIRESTORE_EMULATOR_HOST", "localhost:1")
+	for rawURL, want := range map[string]string{
+		"firestore://my-project?collection=certs": "projects/my-project/databases/(default)/documents/certs",
+		"firestore://my-project":                  "projects/my-project/databases/(default)/documents/" + defaultFirestoreCertCacheCollectionName,
+	} {
+		c, err := Open(ctx, rawURL)
+		if err != nil {
+			t.Fatalf("%s: Open failed: %s", rawURL, err)
+		}
+		f := c.(*Firestore)
+		if path := f.client.Collection(f.collectionName).Path; path != want {
+			t.Fatalf("%s: expected collection %s, got %s", rawURL, want, path)
+		}
+		f.Close(ctx)
+	}
+
+	t.Setenv("FIRESTORE_EMULATOR_HOST", "")
+	_, err := Open(ctx, "firestore://my-project?credentials=/nonexistent/creds.json")
+	if err == nil || !strings.Contains(err.Error(), "/nonexistent/creds.json") {
+		t.Fatalf("expected the credentials file to be read, got %v", err)
+	}
+}
+
+func TestOpenLayered(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c, err := Open(ctx, "layered:mem://, dir://"+dir+",mem://")
+	if err != nil {
+		t.Fatalf("Open failed: %s", err)
+	}
+	l, ok := c.(*LayeredCache)
+	if !ok || len(l.layers) != 3 {
+		t.Fatalf("expected a LayeredCache of 3 layers, got %#v", c)
+	}
+	if _, ok := l.layers[1].cache.(*Dir); !ok {
+		t.Fatalf("expected the layers in order, got %#v", l.layers[1].cache)
+	}
+	if err := c.Put(ctx, "a.com", []byte("cert")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if data, _ := NewDir(dir).Get(ctx, "a.com"); string(data) != "cert" {
+		t.Fatal("expected the write to reach the dir layer")
+	}
+
+	for _, rawURL := range []string{"bogus://", "layered:mem://,bogus://", "layered:", "::"} {
+		if _, err := Open(ctx, rawURL); err == nil {
+			t.Fatalf("expected %q to fail", rawURL)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if schemes := Schemes(); !slices.Contains(schemes, "mem") || !slices.IsSorted(schemes) {
+		t.Fatalf("expected the sorted built in schemes, got %v", schemes)
+	}
+	for name, register := range map[string]func(){
+		"duplicate": func() {
+			Register("mem", func(context.Context, *url.URL) (autocert.Cache, error) { return NewMemory(), nil })
+		},
+		"nil": func() { Register("niltest", nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected a %s opener to panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
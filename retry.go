@@ -0,0 +1,137 @@
+package certcache
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// RetryOptions configures how a Retry cache retries failed operations
+type RetryOptions struct {
+	// MaxAttempts is the number of times an operation is attempted
+	// before its error is returned. Defaults to 3
+	MaxAttempts int
+	// Backoff is the base delay before retrying, which doubles after every
+	// attempt. The actual delay is picked at random between zero and the
+	// base delay so that clients don't retry in lockstep. Defaults to 100ms
+	Backoff time.Duration
+	// MaxBackoff caps the base delay. Defaults to 5 seconds
+	MaxBackoff time.Duration
+	// Timeout bounds each operation, retries included.
+	// No retry is made which could not complete in time
+	Timeout time.Duration
+	// Retryable reports whether a failed operation should be retried.
+	// Defaults to IsRetryable. ErrCacheMiss is never retried
+	Retryable func(error) bool
+}
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBackoff     = 100 * time.Millisecond
+	defaultRetryMaxBackoff  = 5 * time.Second
+)
+
+// Retry is an autocert.Cache which retries the operations of another cache
+// with jittered exponential backoff, e.g. while its backend is throttling
+type Retry struct {
+	cache autocert.Cache
+	opts  RetryOptions
+}
+
+// NewRetry wraps the cache so that its failed operations are retried
+func NewRetry(cache autocert.Cache, opts RetryOptions) *Retry {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultRetryBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if opts.Retryable == nil {
+		opts.Retryable = IsRetryable
+	}
+	return &Retry{cache: cache, opts: opts}
+}
+
+// IsRetryable reports whether an error is transient: the backend was
+// unavailable or throttled the operation (e.g. S3 SlowDown, DynamoDB
+// ProvisionedThroughputExceeded, Mongo transient transaction errors,
+// gRPC Unavailable). Errors of the AWS, Mongo and gRPC clients are
+// recognized even when returned by a cache which does not classify them
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, autocert.ErrCacheMiss) ||
+		errors.Is(err, context.Canceled) {
+		return false
+	}
+	for _, class := range []func(error) error{awsError, mongoError, grpcError} {
+		err = class(err)
+	}
+	return errors.Is(err, ErrUnavailable) || errors.Is(err, ErrThrottled)
+}
+
+// Get returns a certificate data for the specified key.
+// If there's no such key, Get returns ErrCacheMiss.
+func (r *Retry) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := r.do(ctx, func(ctx context.Context) error {
+		var err error
+		data, err = r.cache.Get(ctx, key)
+		return err
+	})
+	return data, err
+}
+
+// Put stores the data in the cache under the specified key.
+// Underlying implementations may use any data storage format,
+// as long as the reverse operation, Get, results in the original data.
+func (r *Retry) Put(ctx context.Context, key string, data []byte) error {
+	return r.do(ctx, func(ctx context.Context) error {
+		return r.cache.Put(ctx, key, data)
+	})
+}
+
+// Delete removes a certificate data from the cache under the specified key.
+// If there's no such key in the cache, Delete returns nil.
+func (r *Retry) Delete(ctx context.Context, key string) error {
+	return r.do(ctx, func(ctx context.Context) error {
+		return r.cache.Delete(ctx, key)
+	})
+}
+
+// Unwrap returns the wrapped cache
+func (r *Retry) Unwrap() autocert.Cache {
+	return r.cache
+}
+
+// do runs the operation until it succeeds, fails with an error
+// which is not retryable, or runs out of attempts or time
+func (r *Retry) do(ctx context.Context, op func(context.Context) error) error {
+	if r.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.opts.Timeout)
+		defer cancel()
+	}
+	backoff := r.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil || attempt >= r.opts.MaxAttempts ||
+			errors.Is(err, autocert.ErrCacheMiss) || !r.opts.Retryable(err) {
+			return err
+		}
+		delay := rand.N(backoff + 1)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		backoff = min(2*backoff, r.opts.MaxBackoff)
+	}
+}
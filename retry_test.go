@@ -0,0 +1,78 @@
+package certcache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestRetryRetriesRetryableErrors(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	throttled := NewFunctional(func(context.Context, string) ([]byte, error) {
+		if calls++; calls < 3 {
+			return nil, awsError(awserr.New("SlowDown", "slow down", nil))
+		}
+		return []byte("cert"), nil
+	}, nil, nil)
+	r := NewRetry(throttled, RetryOptions{Backoff: time.Millisecond})
+	if data, err := r.Get(ctx, "a.com"); err != nil || string(data) != "cert" || calls != 3 {
+		t.Fatalf("expected a hit on the third attempt, got %q, %v after %d calls", data, err, calls)
+	}
+}
+
+func TestRetryGivesUpOnOtherErrors(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	c := NewFunctional(nil, func(context.Context, string, []byte) error {
+		calls++
+		return errBoom
+	}, func(context.Context, string) error {
+		calls++
+		return autocert.ErrCacheMiss
+	})
+	r := NewRetry(c, RetryOptions{Backoff: time.Millisecond})
+	if err := r.Put(ctx, "a.com", nil); !errors.Is(err, errBoom) {
+		t.Fatalf("expected the error, got %v", err)
+	}
+	if err := r.Delete(ctx, "a.com"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected a miss, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected no retries, got %d calls", calls)
+	}
+}
+
+func TestRetryTimeout(t *testing.T) {
+	ctx := context.Background()
+	unavailable := NewFunctional(func(context.Context, string) ([]byte, error) {
+		return nil, classify(ErrUnavailable, errBoom)
+	}, nil, nil)
+	r := NewRetry(unavailable, RetryOptions{
+		MaxAttempts: 100,
+		Backoff:     50 * time.Millisecond,
+		Timeout:     100 * time.Millisecond,
+	})
+	start := time.Now()
+	if _, err := r.Get(ctx, "a.com"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected the last error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the timeout to bound the retries, took %s", elapsed)
+	}
+}
+
+func TestRetryIsLookedThrough(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.Put(ctx, "a.com", []byte("cert"))
+	keys, err := Keys(ctx, NewLayered(NewRetry(m, RetryOptions{})), "")
+	if err != nil || !slices.Equal(keys, []string{"a.com"}) {
+		t.Fatalf("expected the keys of the wrapped cache, got %v, %v", keys, err)
+	}
+}
@@ -0,0 +1,111 @@
+package certcache
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// LayerMaxAge makes hits on the layer older than d be served right away and
+// revalidated against the deeper layers in the background (stale while
+// revalidate). The age of an entry is the time since this LayeredCache last
+// wrote or revalidated it, entries of unknown age are considered stale.
+// This bounds how long a shallow layer serves a certificate which was
+// renewed elsewhere, without adding latency to reads
+func LayerMaxAge(d time.Duration) LayerOption {
+	return func(l *layer) { l.maxAge = d }
+}
+
+// stale reports whether the key needs to be revalidated, and if so marks
+// it as being revalidated so that concurrent hits don't revalidate it again
+func (l *layer) stale(key string) bool {
+	if l.maxAge <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.revalidating[key] {
+		return false
+	}
+	if validated, ok := l.validated[key]; ok && time.Since(validated) <= l.maxAge {
+		return false
+	}
+	if l.revalidating == nil {
+		l.revalidating = make(map[string]bool)
+	}
+	l.revalidating[key] = true
+	return true
+}
+
+// track records that the layer was just written to under the key
+func (l *layer) track(op Op, key string) {
+	if l.maxAge <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if op == OpDelete {
+		delete(l.validated, key)
+		return
+	}
+	if l.validated == nil {
+		l.validated = make(map[string]time.Time)
+	}
+	l.validated[key] = time.Now()
+}
+
+// revalidate compares the data served by the layer at index hit with the
+// deeper layers and brings the deeper data up if it differs. A key missing
+// from every deeper layer is evicted from the layers above them, unless a
+// write of it is yet to reach them under PolicyWriteBehind
+func (c *LayeredCache) revalidate(key string, data []byte, hit int) {
+	l := c.layers[hit]
+	defer func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.revalidating, key)
+	}()
+
+	ctx := context.Background()
+	queried := 0
+	for _, i := range c.readers(key) {
+		if i <= hit {
+			continue
+		}
+		if !c.layers[i].healthy() {
+			// the skipped layer may have the key, try again on the next hit
+			return
+		}
+		queried++
+		fresh, err := c.layers[i].get(ctx, key)
+		switch err {
+		case nil:
+			if string(fresh) == string(data) {
+				l.track(OpPut, key)
+			} else {
+				c.promote(ctx, key, fresh, i)
+			}
+			return
+		case autocert.ErrCacheMiss:
+		default:
+			// try again on the next hit
+			return
+		}
+	}
+	if queried == 0 {
+		// there is nothing deeper to revalidate against
+		l.track(OpPut, key)
+		return
+	}
+	// every deeper layer missed, which is expected while the write
+	// which put the key in this layer is still on its way to them
+	if c.behind.pending(key) {
+		return
+	}
+	for i := hit; i >= 0; i-- {
+		if c.layers[i].promotable() {
+			c.layers[i].apply(ctx, OpDelete, key, nil)
+		}
+	}
+}
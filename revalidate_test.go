@@ -0,0 +1,63 @@
+package certcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestRevalidatePromotesFresherData(t *testing.T) {
+	ctx := context.Background()
+	top, deep := NewMemory(), NewMemory()
+	top.Put(ctx, "k", []byte("old"))
+	deep.Put(ctx, "k", []byte("new"))
+	c := NewLayered(Layer(top, LayerMaxAge(time.Hour)), deep)
+
+	c.revalidate("k", []byte("old"), 0)
+	if data, _ := top.Get(ctx, "k"); string(data) != "new" {
+		t.Fatalf("expected the renewed data to be promoted, got %q", data)
+	}
+}
+
+func TestRevalidateEvictsKeysMissingBelow(t *testing.T) {
+	ctx := context.Background()
+	top, middle, deep := NewMemory(), NewMemory(), NewMemory()
+	top.Put(ctx, "k", []byte("v"))
+	c := NewLayered(Layer(top, LayerMaxAge(time.Hour)), middle, deep)
+
+	c.revalidate("k", []byte("v"), 0)
+	if _, err := top.Get(ctx, "k"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected the key to be evicted, got %v", err)
+	}
+}
+
+func TestRevalidateKeepsKeysOfSkippedLayers(t *testing.T) {
+	ctx := context.Background()
+	top, deep := NewMemory(), NewMemory()
+	top.Put(ctx, "k", []byte("v"))
+	c := NewLayered(Layer(top, LayerMaxAge(time.Hour)), NewMemory(), deep).
+		WithErrorPolicy(PolicyErrorCooldown)
+	// the deepest layer, which may have the key, is cooling down
+	c.layers[2].markUnhealthy(time.Hour)
+
+	c.revalidate("k", []byte("v"), 0)
+	if _, err := top.Get(ctx, "k"); err != nil {
+		t.Fatalf("expected the key to be kept, got %v", err)
+	}
+}
+
+func TestRevalidateKeepsKeysWrittenBehind(t *testing.T) {
+	ctx := context.Background()
+	top, deep := NewMemory(), NewMemory()
+	top.Put(ctx, "k", []byte("v"))
+	c := NewLayered(Layer(top, LayerMaxAge(time.Hour)), deep).WithWriteBehind(WriteBehindOptions{})
+	// the write is yet to reach the deeper layer
+	c.behind.queue = append(c.behind.queue, &pendingWrite{Seq: 1, Op: OpPut, Key: "k", Data: []byte("v")})
+
+	c.revalidate("k", []byte("v"), 0)
+	if _, err := top.Get(ctx, "k"); err != nil {
+		t.Fatalf("expected the key to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package certcache
+
+import (
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// LayerOption configures the role of a single layer of a LayeredCache
+type LayerOption func(*layer)
+
+// configuredLayer is an autocert.Cache carrying options
+// to be applied when it is used as a layer of a LayeredCache
+type configuredLayer struct {
+	autocert.Cache
+	opts []LayerOption
+}
+
+// Layer attaches options to a cache passed to NewLayered, e.g.
+//
+//	certcache.NewLayered(
+//		certcache.NewMemory(),
+//		certcache.Layer(secrets, certcache.LayerNoPromote()),
+//		certcache.Layer(seed, certcache.LayerReadOnly()),
+//	)
+//
+// Outside of a LayeredCache the returned cache behaves exactly like the given one
+func Layer(cache autocert.Cache, opts ...LayerOption) autocert.Cache {
+	return &configuredLayer{Cache: cache, opts: opts}
+}
+
+// Unwrap returns the cache the options are attached to
+func (cl *configuredLayer) Unwrap() autocert.Cache {
+	return cl.Cache
+}
+
+// LayerReadOnly makes the layer never be written to: it is skipped by Put
+// and Delete, never receives hits from deeper layers, and is never evicted
+// from. Use it for seed stores
+func LayerReadOnly() LayerOption {
+	return func(l *layer) { l.readOnly = true }
+}
+
+// LayerNoPromote keeps hits on the layer from being copied into the layers
+// above it. Use it to keep sensitive data (e.g. ACME account keys) out of
+// less secure layers
+func LayerNoPromote() LayerOption {
+	return func(l *layer) { l.noPromote = true }
+}
+
+// LayerWriteOnly makes the layer never be read from: it is skipped by Get and
+// only receives Put and Delete. Hits from deeper layers are not copied into it.
+// Use it for audit sinks
+func LayerWriteOnly() LayerOption {
+	return func(l *layer) { l.writeOnly = true }
+}
+
+// newLayer builds a layer for the cache, applying its options if it was
+// wrapped with Layer
+func newLayer(cache autocert.Cache) *layer {
+	cl, ok := cache.(*configuredLayer)
+	if !ok {
+		return &layer{cache: cache}
+	}
+	l := &layer{cache: cl.Cache}
+	for _, opt := range cl.opts {
+		opt(l)
+	}
+	return l
+}
+
+// readable reports whether Get may read from the layer
+func (l *layer) readable() bool {
+	return !l.writeOnly
+}
+
+// writable reports whether Put and Delete may write to the layer
+func (l *layer) writable() bool {
+	return !l.readOnly
+}
+
+// promotable reports whether hits from deeper layers may be copied into the layer
+func (l *layer) promotable() bool {
+	return !l.readOnly && !l.writeOnly
+}
+
+// readers returns the indexes of the layers Get may read the key from
+func (c *LayeredCache) readers(key string) []int {
+	allowed := c.routed(key)
+	var indexes []int
+	for i, l := range c.layers {
+		if allowed[i] && l.readable() {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// writers returns the indexes of the layers Put and Delete may write
+// the key to, shallowest first
+func (c *LayeredCache) writers(key string) []int {
+	allowed := c.routed(key)
+	var indexes []int
+	for i, l := range c.layers {
+		if allowed[i] && l.writable() {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
@@ -0,0 +1,103 @@
+package certcache
+
+import (
+	"strings"
+)
+
+// KeyClass is the kind of data autocert stores under a key
+type KeyClass string
+
+const (
+	// KeyClassCert is an ECDSA certificate and private key,
+	// stored under "<domain>"
+	KeyClassCert = KeyClass("CERT")
+	// KeyClassRSACert is an RSA certificate and private key,
+	// stored under "<domain>+rsa"
+	KeyClassRSACert = KeyClass("RSA_CERT")
+	// KeyClassAccount is the ACME account private key,
+	// stored under "acme_account+key" (or "acme_account.key" by older versions)
+	KeyClassAccount = KeyClass("ACCOUNT")
+	// KeyClassHTTPToken is an http-01 challenge response,
+	// stored under "<token>+http-01"
+	KeyClassHTTPToken = KeyClass("HTTP_TOKEN")
+	// KeyClassTLSALPNToken is a tls-alpn-01 challenge certificate,
+	// stored under "<domain>+token"
+	KeyClassTLSALPNToken = KeyClass("TLS_ALPN_TOKEN")
+)
+
+const (
+	autocertAccountKey       = "acme_account+key"
+	autocertLegacyAccountKey = "acme_account.key"
+	autocertRSASuffix        = "+rsa"
+	autocertHTTPTokenSuffix  = "+http-01"
+	autocertTLSALPNSuffix    = "+token"
+)
+
+// ParseKey classifies a key following autocert's naming scheme. It also
+// returns the name the key refers to: the domain for certificates and
+// tls-alpn-01 tokens, the token for http-01 challenges, and nothing for
+// the account key. Unrecognized keys are treated as ECDSA certificates
+// since that is how autocert names them
+func ParseKey(key string) (KeyClass, string) {
+	switch {
+	case key == autocertAccountKey || key == autocertLegacyAccountKey:
+		return KeyClassAccount, ""
+	case strings.HasSuffix(key, autocertRSASuffix):
+		return KeyClassRSACert, strings.TrimSuffix(key, autocertRSASuffix)
+	case strings.HasSuffix(key, autocertHTTPTokenSuffix):
+		return KeyClassHTTPToken, strings.TrimSuffix(key, autocertHTTPTokenSuffix)
+	case strings.HasSuffix(key, autocertTLSALPNSuffix):
+		return KeyClassTLSALPNToken, strings.TrimSuffix(key, autocertTLSALPNSuffix)
+	default:
+		return KeyClassCert, key
+	}
+}
+
+// Router selects the layers of a LayeredCache (by index, starting at zero
+// for the top layer) which a key is read from and written to. Returning nil
+// selects every layer
+type Router func(key string) []int
+
+// RouteByClass returns a Router which sends each class of key to the given
+// layers, e.g. to keep account keys in a secrets store and tokens in memory:
+//
+//	certcache.RouteByClass(map[certcache.KeyClass][]int{
+//		certcache.KeyClassAccount:   {2},
+//		certcache.KeyClassHTTPToken: {0},
+//	})
+//
+// Classes without a route go to every layer
+func RouteByClass(routes map[KeyClass][]int) Router {
+	return func(key string) []int {
+		class, _ := ParseKey(key)
+		return routes[class]
+	}
+}
+
+// WithRouter restricts every key to the layers selected by the router.
+// Roles given with Layer still apply within the selected layers
+func (c *LayeredCache) WithRouter(r Router) *LayeredCache {
+	c.router = r
+	return c
+}
+
+// routed returns whether each layer may hold the key
+func (c *LayeredCache) routed(key string) []bool {
+	allowed := make([]bool, len(c.layers))
+	var selected []int
+	if c.router != nil {
+		selected = c.router(key)
+	}
+	if selected == nil {
+		for i := range allowed {
+			allowed[i] = true
+		}
+		return allowed
+	}
+	for _, i := range selected {
+		if i >= 0 && i < len(allowed) {
+			allowed[i] = true
+		}
+	}
+	return allowed
+}
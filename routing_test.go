@@ -0,0 +1,77 @@
+package certcache
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestParseKey(t *testing.T) {
+	for _, tt := range []struct {
+		key   string
+		class KeyClass
+		name  string
+	}{
+		{key: "example.com", class: KeyClassCert, name: "example.com"},
+		{key: "example.com+rsa", class: KeyClassRSACert, name: "example.com"},
+		{key: "acme_account+key", class: KeyClassAccount},
+		{key: "acme_account.key", class: KeyClassAccount},
+		{key: "Xj3k_tok-en+http-01", class: KeyClassHTTPToken, name: "Xj3k_tok-en"},
+		{key: "example.com+token", class: KeyClassTLSALPNToken, name: "example.com"},
+		// anything else is named like an ECDSA certificate
+		{key: "something+else", class: KeyClassCert, name: "something+else"},
+	} {
+		if class, name := ParseKey(tt.key); class != tt.class || name != tt.name {
+			t.Errorf("expected %q to be a %s of %q, got a %s of %q", tt.key, tt.class, tt.name, class, name)
+		}
+	}
+}
+
+func TestRouteByClassKeepsTokensShallow(t *testing.T) {
+	ctx := context.Background()
+	const token = "tok+http-01"
+	top, deep := NewMemory(), NewMemory()
+	// fails the test if the token reaches it
+	guarded := NewFunctional(func(ctx context.Context, key string) ([]byte, error) {
+		if key == token {
+			t.Errorf("unexpected Get of %s on the deep layer", key)
+		}
+		return deep.Get(ctx, key)
+	}, func(ctx context.Context, key string, data []byte) error {
+		if key == token {
+			t.Errorf("unexpected Put of %s on the deep layer", key)
+		}
+		return deep.Put(ctx, key, data)
+	}, func(ctx context.Context, key string) error {
+		if key == token {
+			t.Errorf("unexpected Delete of %s on the deep layer", key)
+		}
+		return deep.Delete(ctx, key)
+	})
+	c := NewLayered(top, guarded).WithRouter(RouteByClass(map[KeyClass][]int{
+		KeyClassHTTPToken: {0},
+	}))
+
+	if err := c.Put(ctx, token, []byte("response")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if data, err := c.Get(ctx, token); err != nil || string(data) != "response" {
+		t.Fatalf("expected the token, got %q, %v", data, err)
+	}
+	top.Delete(ctx, token)
+	if _, err := c.Get(ctx, token); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected a miss without looking deeper, got %v", err)
+	}
+	if err := c.Delete(ctx, token); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+
+	// other classes still go to every layer
+	if err := c.Put(ctx, "example.com", []byte("cert")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if data, _ := deep.Get(ctx, "example.com"); string(data) != "cert" {
+		t.Fatal("expected certificates to reach the deep layer")
+	}
+}
@@ -5,13 +5,12 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
-	"net/http"
+	"iter"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"golang.org/x/crypto/acme/autocert"
@@ -22,6 +21,7 @@ type S3 struct {
 	client  s3iface.S3API
 	bucket  string
 	timeout time.Duration
+	readiness
 }
 
 const (
@@ -30,29 +30,51 @@ const (
 	defaultS3CertCacheTimeout      = 10 * time.Second
 )
 
-// NewS3 returns an S3 certificate cache
+// NewS3 returns an S3 certificate cache. If the AWS session can't be
+// configured, the error is logged and every request fails with it.
+// Use NewS3WithOptions to handle that error instead
 func NewS3(credentials *credentials.Credentials, bucket, region string) *S3 {
-	if region == "" {
-		region = defaultS3CertCacheBucketRegion
+	// with a lenient session, the constructor can't fail
+	s, _ := NewS3WithOptions(context.Background(),
+		WithAWSCredentials(credentials),
+		WithName(bucket),
+		WithRegion(region),
+		withLenientSession(),
+	)
+	return s
+}
+
+// NewS3WithOptions returns an S3 certificate cache. The bucket is given
+// with WithName, and a pre-built client can be given with WithS3Client
+func NewS3WithOptions(ctx context.Context, opts ...Option) (*S3, error) {
+	o := newOptions(opts)
+	if o.name == "" {
+		o.name = defaultS3CertCacheBucketName
 	}
-	if bucket == "" {
-		bucket = defaultS3CertCacheBucketName
+	if o.timeout == 0 {
+		o.timeout = defaultS3CertCacheTimeout
 	}
-	svc := s3.New(session.New(), &aws.Config{
-		Credentials: credentials,
-		Region:      aws.String(region),
-		HTTPClient:  &http.Client{Timeout: defaultS3CertCacheTimeout},
-	})
-	return &S3{
-		bucket: bucket,
-		client: svc,
+	client := o.s3Client
+	if client == nil {
+		sess, err := newAWSSession(o, defaultS3CertCacheBucketRegion)
+		if err != nil {
+			return nil, err
+		}
+		client = s3.New(sess)
 	}
+	return &S3{
+		bucket:  o.name,
+		client:  client,
+		timeout: o.timeout,
+	}, nil
 }
 
 // Get returns a certificate data for the specified key.
 // If there's no such key, Get returns ErrCacheMiss.
 func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
-	results, err := s.client.GetObject(&s3.GetObjectInput{
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	results, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 	})
@@ -65,13 +87,13 @@ func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
 				return nil, autocert.ErrCacheMiss
 			}
 		}
-		return nil, err
+		return nil, fmt.Errorf("could not fetch object %s: %w", key, awsError(err))
 	}
 	defer results.Body.Close()
 
 	data, err := ioutil.ReadAll(results.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read object body for %s: %s", key, err)
+		return nil, fmt.Errorf("failed to read object body for %s: %w", key, classify(ErrUnavailable, err))
 	}
 
 	return data, nil
@@ -81,13 +103,15 @@ func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
 // Underlying implementations may use any data storage format,
 // as long as the reverse operation, Get, results in the original data.
 func (s *S3) Put(ctx context.Context, key string, data []byte) error {
-	if _, err := s.client.PutObject(&s3.PutObjectInput{
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	if _, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
 		Bucket:        aws.String(s.bucket),
 		Key:           aws.String(key),
 		Body:          bytes.NewReader(data),
 		ContentLength: aws.Int64(int64(len(data))),
 	}); err != nil {
-		return fmt.Errorf("failed to store object %s: %s", key, err)
+		return fmt.Errorf("failed to store object %s: %w", key, awsError(err))
 	}
 	return nil
 }
@@ -95,11 +119,86 @@ func (s *S3) Put(ctx context.Context, key string, data []byte) error {
 // Delete removes a certificate data from the cache under the specified key.
 // If there's no such key in the cache, Delete returns nil.
 func (s *S3) Delete(ctx context.Context, key string) error {
-	if _, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	if _, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 	}); err != nil {
-		return fmt.Errorf("failed to delete object %s: %s", key, err)
+		return fmt.Errorf("failed to delete object %s: %w", key, awsError(err))
 	}
 	return nil
 }
+
+// Ping checks that the bucket exists and can be reached
+func (s *S3) Ping(ctx context.Context) error {
+	return s.ping(func() error {
+		ctx, cancel := withTimeout(ctx, s.timeout)
+		defer cancel()
+		if _, err := s.client.HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+			Bucket: aws.String(s.bucket),
+		}); err != nil {
+			return fmt.Errorf("could not reach bucket %s: %w", s.bucket, awsError(err))
+		}
+		return nil
+	})
+}
+
+// Close marks the cache closed. The S3 client holds no
+// connection which needs to be released
+func (s *S3) Close(ctx context.Context) error {
+	return s.close(func() error { return nil })
+}
+
+// List iterates over the keys starting with prefix, in lexicographic order
+func (s *S3) List(ctx context.Context, prefix string) iter.Seq2[KeyInfo, error] {
+	return func(yield func(KeyInfo, error) bool) {
+		stopped := false
+		err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket: aws.String(s.bucket),
+			Prefix: aws.String(prefix),
+		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				info := KeyInfo{
+					Key:     aws.StringValue(obj.Key),
+					Size:    aws.Int64Value(obj.Size),
+					ModTime: aws.TimeValue(obj.LastModified),
+				}
+				if !yield(info, nil) {
+					stopped = true
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil && !stopped {
+			yield(KeyInfo{}, fmt.Errorf("could not list objects in %s: %w", s.bucket, awsError(err)))
+		}
+	}
+}
+
+// Stat describes a single key.
+// If there's no such key, Stat returns ErrCacheMiss.
+func (s *S3) Stat(ctx context.Context, key string) (KeyInfo, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	head, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			// HEAD responses have no body, so a missing key is only a status
+			case "NotFound", s3.ErrCodeNoSuchBucket, s3.ErrCodeNoSuchKey:
+				return KeyInfo{}, autocert.ErrCacheMiss
+			}
+		}
+		return KeyInfo{}, fmt.Errorf("could not describe object %s: %w", key, awsError(err))
+	}
+	return KeyInfo{
+		Key:     key,
+		Size:    aws.Int64Value(head.ContentLength),
+		ModTime: aws.TimeValue(head.LastModified),
+	}, nil
+}
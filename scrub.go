@@ -0,0 +1,190 @@
+package certcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Resolver picks the correct data for a key given the copies held by the
+// layers of a LayeredCache, indexed by layer (nil where a layer does not
+// hold the key)
+type Resolver func(key string, copies [][]byte) ([]byte, error)
+
+// ScrubOptions configures a consistency check of a LayeredCache
+type ScrubOptions struct {
+	// Keys returns the keys to check. Defaults to every key held by
+	// the layers implementing Lister (see LayeredCache.List)
+	Keys func(ctx context.Context) ([]string, error)
+	// Repair makes the scrubber overwrite every divergent copy
+	// with the data picked by Resolve
+	Repair bool
+	// Resolve picks the correct copy when repairing.
+	// Defaults to ResolveDeepest
+	Resolve Resolver
+}
+
+// ScrubReport is the outcome of a consistency check
+type ScrubReport struct {
+	Checked   int
+	Divergent []Divergence
+}
+
+// Divergence describes a key whose copies differ across layers
+type Divergence struct {
+	Key string
+	// Hashes holds the hex encoded SHA-256 of the copy in each layer,
+	// empty where the layer does not hold the key (or can't be read)
+	Hashes []string
+	// Repaired is true if every divergent copy was overwritten
+	Repaired bool
+	// Err is the reason the key could not be repaired, if any
+	Err error
+}
+
+// ResolveDeepest trusts the copy in the deepest layer holding the key
+func ResolveDeepest(key string, copies [][]byte) ([]byte, error) {
+	for i := len(copies) - 1; i >= 0; i-- {
+		if copies[i] != nil {
+			return copies[i], nil
+		}
+	}
+	return nil, autocert.ErrCacheMiss
+}
+
+// ResolveNewest trusts the copy holding the most recently issued certificate.
+// Keys which don't hold certificates (e.g. the account key) are resolved with
+// ResolveDeepest
+func ResolveNewest(key string, copies [][]byte) ([]byte, error) {
+	var (
+		newest []byte
+		issued time.Time
+	)
+	for i := len(copies) - 1; i >= 0; i-- {
+		leaf, err := leafCertificate(copies[i])
+		if err != nil {
+			continue
+		}
+		if newest == nil || leaf.NotBefore.After(issued) {
+			newest, issued = copies[i], leaf.NotBefore
+		}
+	}
+	if newest == nil {
+		return ResolveDeepest(key, copies)
+	}
+	return newest, nil
+}
+
+// leafCertificate parses the first certificate in the PEM data
+// stored by autocert (a private key followed by the certificate chain)
+func leafCertificate(data []byte) (*x509.Certificate, error) {
+	for rest := data; len(rest) > 0; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+	return nil, errors.New("no certificate found")
+}
+
+// Scrub compares the copies of every key across the layers and reports the
+// keys whose copies differ. Layers which don't hold a key are not considered
+// divergent, since shallow layers only hold what they were given. With
+// Repair set, divergent copies are overwritten with the resolved data
+func (c *LayeredCache) Scrub(ctx context.Context, opts ScrubOptions) (ScrubReport, error) {
+	if opts.Keys == nil {
+		if !c.listable() {
+			return ScrubReport{}, errors.New("scrubbing requires a source of keys or a layer implementing Lister")
+		}
+		opts.Keys = func(ctx context.Context) ([]string, error) {
+			return Keys(ctx, c, "")
+		}
+	}
+	if opts.Resolve == nil {
+		opts.Resolve = ResolveDeepest
+	}
+	keys, err := opts.Keys(ctx)
+	if err != nil {
+		return ScrubReport{}, fmt.Errorf("failed to list keys to scrub: %w", err)
+	}
+	var report ScrubReport
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Checked++
+		if d, divergent := c.scrubKey(ctx, key, opts); divergent {
+			report.Divergent = append(report.Divergent, d)
+		}
+	}
+	return report, nil
+}
+
+// ScrubEvery runs Scrub at the given interval and hands each report to fn.
+// It blocks until the context is done
+func (c *LayeredCache) ScrubEvery(ctx context.Context, interval time.Duration, opts ScrubOptions, fn func(ScrubReport, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn(c.Scrub(ctx, opts))
+		}
+	}
+}
+
+func (c *LayeredCache) scrubKey(ctx context.Context, key string, opts ScrubOptions) (Divergence, bool) {
+	copies := make([][]byte, len(c.layers))
+	d := Divergence{Key: key, Hashes: make([]string, len(c.layers))}
+	var first []byte
+	divergent := false
+	for _, i := range c.readers(key) {
+		// read the layer directly so that scrubbing does not skew its stats
+		data, err := c.layers[i].cache.Get(ctx, key)
+		if err != nil {
+			continue
+		}
+		copies[i] = data
+		sum := sha256.Sum256(data)
+		d.Hashes[i] = hex.EncodeToString(sum[:])
+		if first == nil {
+			first = data
+		} else if !bytes.Equal(first, data) {
+			divergent = true
+		}
+	}
+	if !divergent || !opts.Repair {
+		return d, divergent
+	}
+
+	resolved, err := opts.Resolve(key, copies)
+	if err != nil {
+		d.Err = fmt.Errorf("failed to resolve: %w", err)
+		return d, true
+	}
+	var errs []error
+	for i, data := range copies {
+		if data == nil || bytes.Equal(data, resolved) || !c.layers[i].writable() {
+			continue
+		}
+		if err := c.layers[i].cache.Put(ctx, key, resolved); err != nil {
+			errs = append(errs, fmt.Errorf("layer %d (%s): %w", i, c.layers[i].name(), err))
+		}
+	}
+	d.Err = errors.Join(errs...)
+	d.Repaired = d.Err == nil
+	return d, true
+}
@@ -0,0 +1,162 @@
+package certcache
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certPEM returns data shaped like what autocert stores for a
+// certificate (a private key followed by the chain) issued at the given time
+func certPEM(t *testing.T, issued time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "a.com"},
+		NotBefore:    issued,
+		NotAfter:     issued.Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+}
+
+func TestScrubReportsDivergence(t *testing.T) {
+	ctx := context.Background()
+	top, deep := NewMemory(), NewMemory()
+	top.Put(ctx, "a.com", []byte("stale"))
+	deep.Put(ctx, "a.com", []byte("fresh"))
+	top.Put(ctx, "b.com", []byte("same"))
+	deep.Put(ctx, "b.com", []byte("same"))
+	// shallow layers only hold what they were given
+	deep.Put(ctx, "c.com", []byte("deep only"))
+	c := NewLayered(top, deep)
+
+	report, err := c.Scrub(ctx, ScrubOptions{})
+	if err != nil {
+		t.Fatalf("Scrub failed: %s", err)
+	}
+	if report.Checked != 3 || len(report.Divergent) != 1 || report.Divergent[0].Key != "a.com" {
+		t.Fatalf("expected a.com only to diverge out of 3 keys, got %+v", report)
+	}
+	d := report.Divergent[0]
+	if d.Repaired || d.Hashes[0] == "" || d.Hashes[0] == d.Hashes[1] {
+		t.Fatalf("expected distinct hashes and no repair, got %+v", d)
+	}
+	if data, _ := top.Get(ctx, "a.com"); string(data) != "stale" {
+		t.Fatal("expected nothing to be written without Repair")
+	}
+}
+
+func TestScrubRepairs(t *testing.T) {
+	ctx := context.Background()
+	for name, tt := range map[string]struct {
+		resolve Resolver
+		want    string
+	}{
+		"deepest by default": {want: "deep"},
+		"resolver": {
+			resolve: func(key string, copies [][]byte) ([]byte, error) { return copies[0], nil },
+			want:    "top",
+		},
+	} {
+		top, deep := NewMemory(), NewMemory()
+		top.Put(ctx, "a.com", []byte("top"))
+		deep.Put(ctx, "a.com", []byte("deep"))
+		c := NewLayered(top, deep)
+
+		report, err := c.Scrub(ctx, ScrubOptions{Repair: true, Resolve: tt.resolve})
+		if err != nil || len(report.Divergent) != 1 || !report.Divergent[0].Repaired {
+			t.Fatalf("%s: expected a.com to be repaired, got %+v, %v", name, report, err)
+		}
+		for _, m := range []*Memory{top, deep} {
+			if data, _ := m.Get(ctx, "a.com"); string(data) != tt.want {
+				t.Fatalf("%s: expected every copy to be %q, got %q", name, tt.want, data)
+			}
+		}
+	}
+}
+
+func TestScrubRepairFailures(t *testing.T) {
+	ctx := context.Background()
+	top, deep := NewMemory(), NewMemory()
+	top.Put(ctx, "a.com", []byte("top"))
+	deep.Put(ctx, "a.com", []byte("deep"))
+	keys := func(context.Context) ([]string, error) { return []string{"a.com"}, nil }
+
+	unwritable := NewFunctional(top.Get, func(context.Context, string, []byte) error { return errBoom }, top.Delete)
+	c := NewLayered(unwritable, deep)
+	report, err := c.Scrub(ctx, ScrubOptions{Keys: keys, Repair: true})
+	if err != nil || len(report.Divergent) != 1 {
+		t.Fatalf("expected a.com to diverge, got %+v, %v", report, err)
+	}
+	if d := report.Divergent[0]; d.Repaired || !errors.Is(d.Err, errBoom) {
+		t.Fatalf("expected the failed write to be reported, got %+v", d)
+	}
+
+	unresolvable := func(string, [][]byte) ([]byte, error) { return nil, errBoom }
+	report, _ = NewLayered(top, deep).Scrub(ctx, ScrubOptions{Repair: true, Resolve: unresolvable})
+	if d := report.Divergent[0]; d.Repaired || !errors.Is(d.Err, errBoom) {
+		t.Fatalf("expected the resolver error to be reported, got %+v", d)
+	}
+	if data, _ := top.Get(ctx, "a.com"); string(data) != "top" {
+		t.Fatal("expected nothing to be written when resolving fails")
+	}
+}
+
+func TestScrubRequiresKeys(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	unlistable := NewFunctional(m.Get, m.Put, m.Delete)
+	c := NewLayered(unlistable)
+	if _, err := c.Scrub(ctx, ScrubOptions{}); err == nil {
+		t.Fatal("expected scrubbing without a source of keys to fail")
+	}
+	keys := func(context.Context) ([]string, error) { return nil, errBoom }
+	if _, err := c.Scrub(ctx, ScrubOptions{Keys: keys}); !errors.Is(err, errBoom) {
+		t.Fatalf("expected the key source error, got %v", err)
+	}
+}
+
+func TestResolvers(t *testing.T) {
+	now := time.Now()
+	older, newer := certPEM(t, now.Add(-time.Hour)), certPEM(t, now)
+
+	if data, _ := ResolveNewest("a.com", [][]byte{newer, nil, older}); string(data) != string(newer) {
+		t.Fatal("expected the newest certificate to win over the deepest copy")
+	}
+	if data, _ := ResolveNewest("a.com", [][]byte{older, []byte("garbage"), newer}); string(data) != string(newer) {
+		t.Fatal("expected copies without a certificate to be ignored")
+	}
+	// keys without certificates fall back to the deepest copy
+	copies := [][]byte{[]byte("a"), []byte("b"), nil}
+	for name, resolve := range map[string]Resolver{"ResolveNewest": ResolveNewest, "ResolveDeepest": ResolveDeepest} {
+		if data, _ := resolve("acme_account+key", copies); string(data) != "b" {
+			t.Fatalf("%s: expected the deepest copy, got %q", name, data)
+		}
+		if _, err := resolve("a.com", make([][]byte, 2)); err != autocert.ErrCacheMiss {
+			t.Fatalf("%s: expected a miss without copies, got %v", name, err)
+		}
+	}
+}
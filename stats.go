@@ -0,0 +1,132 @@
+package certcache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// LayerStats is a snapshot of the counters of a single layer of a LayeredCache
+type LayerStats struct {
+	Index int
+	Layer string
+	// Hits, Misses and Errors count the outcomes of reads on the layer
+	Hits   int64
+	Misses int64
+	Errors int64
+	// Promotions counts hits from deeper layers copied into this layer
+	Promotions int64
+	// Writes counts the Put and Delete calls made on the layer (including
+	// promotions), WriteErrors those which failed, and WriteLatency is the
+	// total time spent on them
+	Writes       int64
+	WriteErrors  int64
+	WriteLatency time.Duration
+}
+
+// layerCounters are the live counters behind LayerStats
+type layerCounters struct {
+	hits         atomic.Int64
+	misses       atomic.Int64
+	errors       atomic.Int64
+	promotions   atomic.Int64
+	writes       atomic.Int64
+	writeErrors  atomic.Int64
+	writeLatency atomic.Int64
+}
+
+// HitRatio is the fraction of successful reads on the layer which were hits
+func (s LayerStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// AvgWriteLatency is the mean duration of a write on the layer
+func (s LayerStats) AvgWriteLatency() time.Duration {
+	if s.Writes == 0 {
+		return 0
+	}
+	return s.WriteLatency / time.Duration(s.Writes)
+}
+
+// Stats returns a snapshot of the counters of every layer, top layer first
+func (c *LayeredCache) Stats() []LayerStats {
+	stats := make([]LayerStats, 0, len(c.layers))
+	for i, l := range c.layers {
+		stats = append(stats, LayerStats{
+			Index:        i,
+			Layer:        l.name(),
+			Hits:         l.counters.hits.Load(),
+			Misses:       l.counters.misses.Load(),
+			Errors:       l.counters.errors.Load(),
+			Promotions:   l.counters.promotions.Load(),
+			Writes:       l.counters.writes.Load(),
+			WriteErrors:  l.counters.writeErrors.Load(),
+			WriteLatency: time.Duration(l.counters.writeLatency.Load()),
+		})
+	}
+	return stats
+}
+
+// Describe returns a human readable description of the layer chain, e.g.
+//
+//	LayeredCache (write: DEEP_FIRST, read: SERIAL, errors: STRICT)
+//	  0: *certcache.Memory
+//	  1: autocert.DirCache
+//	  2: *certcache.Firestore [no-promote]
+func (c *LayeredCache) Describe() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "LayeredCache (write: %s, read: %s, errors: %s)", c.writePolicy, c.readPolicy, c.errorPolicy)
+	for i, l := range c.layers {
+		fmt.Fprintf(&b, "\n  %d: %s", i, l.name())
+		if roles := l.roles(); len(roles) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(roles, ", "))
+		}
+	}
+	return b.String()
+}
+
+// name is the type of the cache backing the layer
+func (l *layer) name() string {
+	return fmt.Sprintf("%T", l.cache)
+}
+
+func (l *layer) roles() []string {
+	var roles []string
+	if l.readOnly {
+		roles = append(roles, "read-only")
+	}
+	if l.writeOnly {
+		roles = append(roles, "write-only")
+	}
+	if l.noPromote {
+		roles = append(roles, "no-promote")
+	}
+	if l.maxAge > 0 {
+		roles = append(roles, fmt.Sprintf("max-age %s", l.maxAge))
+	}
+	return roles
+}
+
+// get reads the key from the layer, counting the outcome
+func (l *layer) get(ctx context.Context, key string) ([]byte, error) {
+	data, err := l.cache.Get(ctx, key)
+	switch err {
+	case nil:
+		l.counters.hits.Add(1)
+	case autocert.ErrCacheMiss:
+		l.counters.misses.Add(1)
+	default:
+		// lookups cancelled by a parallel read are not failures
+		if ctx.Err() == nil {
+			l.counters.errors.Add(1)
+		}
+	}
+	return data, err
+}
@@ -0,0 +1,141 @@
+package certcache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// LayerError is returned by the write operations of a LayeredCache when one
+// or more of its layers fail. It names every failing layer and, for
+// transactional caches, the layers which could not be rolled back
+type LayerError struct {
+	Op       Op
+	Key      string
+	Failures []LayerFailure
+	// RolledBack is true if the layers written before the failure were
+	// restored to their previous state (see WithTransactions)
+	RolledBack bool
+	// RollbackFailures lists the layers which could not be restored
+	RollbackFailures []LayerFailure
+}
+
+// LayerFailure is the error returned by a single layer
+type LayerFailure struct {
+	Index int
+	Layer string
+	Err   error
+}
+
+func (e *LayerError) Error() string {
+	parts := make([]string, 0, len(e.Failures)+len(e.RollbackFailures))
+	for _, f := range e.Failures {
+		parts = append(parts, f.String())
+	}
+	for _, f := range e.RollbackFailures {
+		parts = append(parts, "rollback of "+f.String())
+	}
+	return fmt.Sprintf("%s of %s failed: %s", e.Op, e.Key, strings.Join(parts, "; "))
+}
+
+// Unwrap returns the errors of the failing layers
+func (e *LayerError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		errs = append(errs, f.Err)
+	}
+	return errs
+}
+
+func (f LayerFailure) String() string {
+	return fmt.Sprintf("layer %d (%s): %s", f.Index, f.Layer, f.Err)
+}
+
+// WithTransactions makes Put and Delete all-or-nothing: the previous value
+// of the key is read from every layer before it is written, and if a layer
+// fails, the layers already written are restored to their previous state.
+// This costs one extra Get per layer on every write
+func (c *LayeredCache) WithTransactions(enabled bool) *LayeredCache {
+	c.transactional = enabled
+	return c
+}
+
+// snapshot is the state of a key in a layer before a write
+type snapshot struct {
+	index   int
+	data    []byte
+	existed bool
+}
+
+func (c *LayeredCache) failure(index int, err error) LayerFailure {
+	return LayerFailure{
+		Index: index,
+		Layer: c.layers[index].name(),
+		Err:   err,
+	}
+}
+
+// snapshot reads the current state of the key in the layer at index
+func (c *LayeredCache) snapshot(ctx context.Context, index int, key string) (snapshot, error) {
+	data, err := c.layers[index].cache.Get(ctx, key)
+	switch err {
+	case nil:
+		return snapshot{index: index, data: data, existed: true}, nil
+	case autocert.ErrCacheMiss:
+		return snapshot{index: index}, nil
+	default:
+		return snapshot{}, fmt.Errorf("failed to read previous value: %w", err)
+	}
+}
+
+// writeInOrder applies the operation to the layers at the given indexes,
+// one at a time, stopping at the first failure
+func (c *LayeredCache) writeInOrder(ctx context.Context, order []int, op Op, key string, data []byte) error {
+	var done []snapshot
+	for _, i := range order {
+		snap := snapshot{index: i}
+		if c.transactional {
+			var err error
+			if snap, err = c.snapshot(ctx, i, key); err != nil {
+				return c.abort(ctx, op, key, []LayerFailure{c.failure(i, err)}, done)
+			}
+		}
+		if err := c.layers[i].apply(ctx, op, key, data); err != nil {
+			return c.abort(ctx, op, key, []LayerFailure{c.failure(i, err)}, done)
+		}
+		done = append(done, snap)
+	}
+	return nil
+}
+
+// abort builds the error for a failed write, rolling back
+// the layers already written if the cache is transactional
+func (c *LayeredCache) abort(ctx context.Context, op Op, key string, failures []LayerFailure, done []snapshot) *LayerError {
+	lerr := &LayerError{Op: op, Key: key, Failures: failures}
+	if c.transactional {
+		lerr.RollbackFailures = c.rollback(ctx, key, done)
+		lerr.RolledBack = len(lerr.RollbackFailures) == 0
+	}
+	return lerr
+}
+
+// rollback restores the snapshots, most recent first. It keeps going
+// when a layer fails, and returns the layers which could not be restored
+func (c *LayeredCache) rollback(ctx context.Context, key string, done []snapshot) []LayerFailure {
+	// the caller's context may be what made the write fail
+	ctx = context.WithoutCancel(ctx)
+	var failures []LayerFailure
+	for i := len(done) - 1; i >= 0; i-- {
+		snap := done[i]
+		op := OpDelete
+		if snap.existed {
+			op = OpPut
+		}
+		if err := c.layers[snap.index].apply(ctx, op, key, snap.data); err != nil {
+			failures = append(failures, c.failure(snap.index, err))
+		}
+	}
+	return failures
+}
@@ -0,0 +1,112 @@
+package certcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// rollbackTest describes a transactional write which fails on one layer
+type rollbackTest struct {
+	policy WritePolicy
+	// failing is the index of the layer which fails every operation
+	failing int
+}
+
+// transactionalCache returns a transactional cache of three layers where the
+// one at index failing fails, along with the two working ones, shallowest
+// first. The first working layer holds "old" under "k", the other nothing
+func (tt rollbackTest) transactionalCache(ctx context.Context) (*LayeredCache, []*Memory) {
+	var layers []autocert.Cache
+	var memories []*Memory
+	for i := 0; i < 3; i++ {
+		if i == tt.failing {
+			layers = append(layers, failingCache())
+			continue
+		}
+		m := NewMemory()
+		layers = append(layers, m)
+		memories = append(memories, m)
+	}
+	memories[0].Put(ctx, "k", []byte("old"))
+	return NewLayeredWithPolicy(tt.policy, layers...).WithTransactions(true), memories
+}
+
+func TestTransactionalWriteRollsBack(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []rollbackTest{
+		// the failing layer is written last, after the other two
+		{policy: PolicyWriteDeepFirst, failing: 0},
+		{policy: PolicyWriteShallowFirst, failing: 2},
+		// the failing layer is written first, so nothing is written at all
+		{policy: PolicyWriteShallowFirst, failing: 0},
+	} {
+		c, memories := tt.transactionalCache(ctx)
+		var lerr *LayerError
+		if err := c.Put(ctx, "k", []byte("new")); !errors.As(err, &lerr) || !lerr.RolledBack || !errors.Is(err, errBoom) {
+			t.Fatalf("%+v: expected a rolled back LayerError, got %v", tt, err)
+		}
+		if len(lerr.Failures) != 1 || lerr.Failures[0].Index != tt.failing {
+			t.Fatalf("%+v: expected layer %d to be named, got %+v", tt, tt.failing, lerr.Failures)
+		}
+		if data, _ := memories[0].Get(ctx, "k"); string(data) != "old" {
+			t.Fatalf("%+v: expected the previous value to be restored, got %q", tt, data)
+		}
+		if _, err := memories[1].Get(ctx, "k"); err != autocert.ErrCacheMiss {
+			t.Fatalf("%+v: expected the new key to be deleted again", tt)
+		}
+
+		// a failed delete puts the data back
+		memories[1].Put(ctx, "k", []byte("other"))
+		if err := c.Delete(ctx, "k"); !errors.As(err, &lerr) || !lerr.RolledBack {
+			t.Fatalf("%+v: expected a rolled back LayerError, got %v", tt, err)
+		}
+		for i, want := range []string{"old", "other"} {
+			if data, _ := memories[i].Get(ctx, "k"); string(data) != want {
+				t.Fatalf("%+v: expected %q to be restored, got %q", tt, want, data)
+			}
+		}
+	}
+}
+
+func TestTransactionalWriteReportsRollbackFailures(t *testing.T) {
+	ctx := context.Background()
+	// accepts the first write, then fails the rollback
+	m := NewMemory()
+	writes := 0
+	once := NewFunctional(m.Get, func(ctx context.Context, key string, data []byte) error {
+		if writes++; writes > 1 {
+			return errBoom
+		}
+		return m.Put(ctx, key, data)
+	}, func(context.Context, string) error { return errBoom })
+	deep := NewMemory()
+
+	c := NewLayered(failingCache(), once, deep).WithTransactions(true)
+	var lerr *LayerError
+	if err := c.Put(ctx, "k", []byte("new")); !errors.As(err, &lerr) || lerr.RolledBack {
+		t.Fatalf("expected a LayerError which was not rolled back, got %v", err)
+	}
+	if len(lerr.RollbackFailures) != 1 || lerr.RollbackFailures[0].Index != 1 {
+		t.Fatalf("expected the rollback of layer 1 to be reported, got %+v", lerr.RollbackFailures)
+	}
+	// the other layers are still rolled back
+	if _, err := deep.Get(ctx, "k"); err != autocert.ErrCacheMiss {
+		t.Fatal("expected the deepest layer to be rolled back")
+	}
+}
+
+func TestTransactionalWriteAbortsOnFailedSnapshot(t *testing.T) {
+	ctx := context.Background()
+	deep := NewMemory()
+	c := NewLayered(unreadableCache(NewMemory()), deep).WithTransactions(true)
+	var lerr *LayerError
+	if err := c.Put(ctx, "k", []byte("new")); !errors.As(err, &lerr) || !lerr.RolledBack {
+		t.Fatalf("expected a rolled back LayerError, got %v", err)
+	}
+	if _, err := deep.Get(ctx, "k"); err != autocert.ErrCacheMiss {
+		t.Fatal("expected the layer written before the failed snapshot to be rolled back")
+	}
+}
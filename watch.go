@@ -0,0 +1,106 @@
+package certcache
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Op is the operation that changed a key
+type Op string
+
+const (
+	// OpPut indicates that data was stored under a key
+	OpPut = Op("PUT")
+	// OpAdd indicates that data was stored under a key which did not exist
+	// before. Only watchers able to tell additions apart report it, others
+	// report OpPut; consumers which don't care should treat both alike
+	OpAdd = Op("ADD")
+	// OpDelete indicates that a key was deleted
+	OpDelete = Op("DELETE")
+)
+
+// Event describes a change to a single key of a cache
+type Event struct {
+	Key  string
+	Op   Op
+	Time time.Time
+	// Source is the cache in which the change was observed, if known
+	Source autocert.Cache
+}
+
+// Watcher is implemented by caches (or their change feeds) which are able to
+// report changes made to them, including those made by other processes
+type Watcher interface {
+	// Watch calls fn for every change until the context is done
+	// (in which case it returns nil) or watching fails
+	Watch(ctx context.Context, fn func(Event)) error
+}
+
+// Bus is an in-process publish/subscribe channel for cache change events
+type Bus struct {
+	sync.RWMutex
+	subs map[int]func(Event)
+	next int
+}
+
+// NewBus returns a bus with no subscribers
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]func(Event))}
+}
+
+// Publish delivers the event to every subscriber.
+// Subscribers are called synchronously, in no particular order,
+// and may subscribe or unsubscribe while being called
+func (b *Bus) Publish(e Event) {
+	b.RLock()
+	subs := make([]func(Event), 0, len(b.subs))
+	for _, fn := range b.subs {
+		subs = append(subs, fn)
+	}
+	b.RUnlock()
+	for _, fn := range subs {
+		fn(e)
+	}
+}
+
+// Subscribe registers fn to be called for every published event.
+// The returned function removes the subscription
+func (b *Bus) Subscribe(fn func(Event)) func() {
+	b.Lock()
+	defer b.Unlock()
+	id := b.next
+	b.next++
+	b.subs[id] = fn
+	return func() {
+		b.Lock()
+		defer b.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+// Forward publishes every event reported by the watcher. Events which do not
+// name their source are attributed to the watcher if it is itself a cache.
+// Forward blocks until the context is done or watching fails
+func (b *Bus) Forward(ctx context.Context, w Watcher) error {
+	source, _ := w.(autocert.Cache)
+	return w.Watch(ctx, func(e Event) {
+		if e.Source == nil {
+			e.Source = source
+		}
+		b.Publish(e)
+	})
+}
+
+// sameCache reports whether two caches are the same value
+// without panicking on implementations which are not comparable
+func sameCache(a, b autocert.Cache) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	return ta == tb && ta.Comparable() && a == b
+}
@@ -0,0 +1,32 @@
+package certcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusSubscriberMayUnsubscribe(t *testing.T) {
+	b := NewBus()
+	var calls int
+	var unsubscribe func()
+	unsubscribe = b.Subscribe(func(Event) {
+		calls++
+		unsubscribe()
+		b.Subscribe(func(Event) {})
+	})
+
+	done := make(chan struct{})
+	go func() {
+		b.Publish(Event{Key: "a.com", Op: OpAdd})
+		b.Publish(Event{Key: "a.com", Op: OpPut})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish deadlocked")
+	}
+	if calls != 1 {
+		t.Fatalf("expected a single call before unsubscribing, got %d", calls)
+	}
+}
@@ -0,0 +1,43 @@
+package certcache
+
+import (
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// wrapper is implemented by caches which add behavior
+// to another cache, such as Retry
+type wrapper interface {
+	Unwrap() autocert.Cache
+}
+
+// findCache returns the first cache implementing T
+// in the chain of caches wrapped by the given one
+func findCache[T any](cache autocert.Cache) (T, bool) {
+	for cache != nil {
+		if t, ok := cache.(T); ok {
+			return t, true
+		}
+		w, ok := cache.(wrapper)
+		if !ok {
+			break
+		}
+		cache = w.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// wraps reports whether the cache is, or wraps, the other cache
+func wraps(cache, other autocert.Cache) bool {
+	for cache != nil {
+		if sameCache(cache, other) {
+			return true
+		}
+		w, ok := cache.(wrapper)
+		if !ok {
+			return false
+		}
+		cache = w.Unwrap()
+	}
+	return false
+}
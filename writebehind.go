@@ -0,0 +1,335 @@
+package certcache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriteBehindOptions configures how PolicyWriteBehind copies writes
+// to the deeper layers of a LayeredCache
+type WriteBehindOptions struct {
+	// JournalDir is a directory in which pending writes are persisted until
+	// every deeper layer has them, so that they survive a restart.
+	// If empty, pending writes are only kept in memory
+	JournalDir string
+	// MaxAttempts is the number of times a write is attempted
+	// before it is given up on. Defaults to 5. Writes given up on are
+	// kept in the journal directory with the .dead extension, where they
+	// are not replayed but can be inspected or renamed back to .json.
+	// Without a journal directory they are only logged
+	MaxAttempts int
+	// Backoff is the delay before retrying a failed write,
+	// which doubles after every attempt. Defaults to 1 second
+	Backoff time.Duration
+}
+
+const (
+	defaultWriteBehindMaxAttempts = 5
+	defaultWriteBehindBackoff     = 1 * time.Second
+	writeBehindJournalExt         = ".json"
+	writeBehindDeadLetterExt      = ".dead"
+)
+
+// writeBehind is a queue of writes to be copied to the deeper layers.
+// Writes are applied one at a time, in the order they were made
+type writeBehind struct {
+	cache *LayeredCache
+	opts  WriteBehindOptions
+
+	mu      sync.Mutex
+	queue   []*pendingWrite
+	running bool
+	seq     uint64
+	waiters []chan struct{}
+}
+
+// pendingWrite is a write yet to reach the deeper layers,
+// as stored in the journal
+type pendingWrite struct {
+	Seq  uint64 `json:"seq"`
+	Op   Op     `json:"op"`
+	Key  string `json:"key"`
+	Data []byte `json:"data,omitempty"`
+}
+
+// WithWriteBehind sets the write policy to PolicyWriteBehind with the given
+// options. If a journal directory is given, the writes left pending by a
+// previous process are replayed right away
+func (c *LayeredCache) WithWriteBehind(opts WriteBehindOptions) *LayeredCache {
+	c.writePolicy = PolicyWriteBehind
+	c.behind = newWriteBehind(c, opts)
+	if err := c.behind.replay(); err != nil {
+		log.Printf("[certcache] failed to replay write-behind journal: %s", err)
+	}
+	return c
+}
+
+// Flush blocks until every write made under PolicyWriteBehind has been copied
+// to the deeper layers (or given up on), or until the context is done.
+// It should be called on shutdown to avoid losing writes
+func (c *LayeredCache) Flush(ctx context.Context) error {
+	return c.behind.flush(ctx)
+}
+
+func newWriteBehind(cache *LayeredCache, opts WriteBehindOptions) *writeBehind {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultWriteBehindMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultWriteBehindBackoff
+	}
+	return &writeBehind{cache: cache, opts: opts}
+}
+
+// enqueue records the write in the journal and schedules it
+func (wb *writeBehind) enqueue(op Op, key string, data []byte) error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.seq++
+	w := &pendingWrite{Seq: wb.seq, Op: op, Key: key, Data: data}
+	if err := wb.persist(w); err != nil {
+		return fmt.Errorf("failed to journal write of %s: %w", key, err)
+	}
+	wb.queue = append(wb.queue, w)
+	wb.start()
+	return nil
+}
+
+// replay loads the writes found in the journal directory and schedules them
+func (wb *writeBehind) replay() error {
+	if wb.opts.JournalDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(wb.opts.JournalDir, 0700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(wb.opts.JournalDir)
+	if err != nil {
+		return err
+	}
+	var (
+		loaded []*pendingWrite
+		maxSeq uint64
+	)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		// dead letters are not replayed, but their numbers must not be
+		// reused or the next write given up on would replace them
+		if seq, ok := strings.CutSuffix(entry.Name(), writeBehindDeadLetterExt); ok {
+			if n, err := strconv.ParseUint(seq, 10, 64); err == nil && n > maxSeq {
+				maxSeq = n
+			}
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), writeBehindJournalExt) {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(wb.opts.JournalDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		var w pendingWrite
+		if err := json.Unmarshal(raw, &w); err != nil {
+			return fmt.Errorf("corrupt journal entry %s: %w", entry.Name(), classify(ErrCorrupt, err))
+		}
+		loaded = append(loaded, &w)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].Seq < loaded[j].Seq })
+
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	for _, w := range loaded {
+		maxSeq = max(maxSeq, w.Seq)
+	}
+	wb.seq = max(wb.seq, maxSeq)
+	wb.queue = append(loaded, wb.queue...)
+	if len(wb.queue) > 0 {
+		wb.start()
+	}
+	return nil
+}
+
+// start launches the worker if it is not running. Must be called with mu held
+func (wb *writeBehind) start() {
+	if !wb.running {
+		wb.running = true
+		go wb.run()
+	}
+}
+
+// run applies queued writes until the queue is empty
+func (wb *writeBehind) run() {
+	for {
+		wb.mu.Lock()
+		if len(wb.queue) == 0 {
+			wb.running = false
+			for _, waiter := range wb.waiters {
+				close(waiter)
+			}
+			wb.waiters = nil
+			wb.mu.Unlock()
+			return
+		}
+		w := wb.queue[0]
+		wb.mu.Unlock()
+
+		if err := wb.apply(w); err != nil {
+			log.Printf("[certcache] giving up on write-behind %s of %s: %s", w.Op, w.Key, err)
+			if err := wb.bury(w); err != nil {
+				log.Printf("[certcache] failed to move %s of %s to write-behind dead letters: %s", w.Op, w.Key, err)
+			}
+		} else if err := wb.unpersist(w); err != nil {
+			log.Printf("[certcache] failed to remove %s of %s from write-behind journal: %s", w.Op, w.Key, err)
+		}
+
+		wb.mu.Lock()
+		wb.queue = wb.queue[1:]
+		wb.mu.Unlock()
+	}
+}
+
+// apply copies the write to the deeper layers, deepest first,
+// retrying with exponential backoff
+func (wb *writeBehind) apply(w *pendingWrite) error {
+	backoff := wb.opts.Backoff
+	var err error
+	for attempt := 1; attempt <= wb.opts.MaxAttempts; attempt++ {
+		if err = wb.applyOnce(w); err == nil {
+			return nil
+		}
+		if attempt < wb.opts.MaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+func (wb *writeBehind) applyOnce(w *pendingWrite) error {
+	ctx := context.Background()
+	order := wb.cache.writers(w.Key)
+	for i := len(order) - 1; i >= 0 && order[i] > 0; i-- {
+		if err := wb.cache.layers[order[i]].apply(ctx, w.Op, w.Key, w.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pending reports whether a write of the key is yet to reach the deeper layers
+func (wb *writeBehind) pending(key string) bool {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	for _, w := range wb.queue {
+		if w.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (wb *writeBehind) flush(ctx context.Context) error {
+	wb.mu.Lock()
+	if !wb.running {
+		wb.mu.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	wb.waiters = append(wb.waiters, done)
+	wb.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// persist writes the pending write to the journal directory (if any).
+// The file is synced and renamed into place, and the directory synced,
+// so that a crash never leaves a partial or missing entry
+func (wb *writeBehind) persist(w *pendingWrite) error {
+	if wb.opts.JournalDir == "" {
+		return nil
+	}
+	raw, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(wb.opts.JournalDir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(wb.opts.JournalDir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), wb.journalPath(w)); err != nil {
+		return err
+	}
+	return syncDir(wb.opts.JournalDir)
+}
+
+func (wb *writeBehind) unpersist(w *pendingWrite) error {
+	if wb.opts.JournalDir == "" {
+		return nil
+	}
+	if err := os.Remove(wb.journalPath(w)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// bury moves the journal entry of a write given up on to a dead letter
+// file, which is not replayed. An existing dead letter is never replaced,
+// in which case the entry is left in the journal to be replayed
+func (wb *writeBehind) bury(w *pendingWrite) error {
+	if wb.opts.JournalDir == "" {
+		return nil
+	}
+	dead := strings.TrimSuffix(wb.journalPath(w), writeBehindJournalExt) + writeBehindDeadLetterExt
+	if _, err := os.Lstat(dead); err == nil {
+		return fmt.Errorf("dead letter %s already exists", filepath.Base(dead))
+	}
+	if err := os.Rename(wb.journalPath(w), dead); err != nil {
+		return err
+	}
+	return syncDir(wb.opts.JournalDir)
+}
+
+// syncDir flushes the entries of a directory, making renames durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (wb *writeBehind) journalPath(w *pendingWrite) string {
+	return filepath.Join(wb.opts.JournalDir, fmt.Sprintf("%020d%s", w.Seq, writeBehindJournalExt))
+}
@@ -0,0 +1,115 @@
+package certcache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteBehindCopiesToDeeperLayers(t *testing.T) {
+	ctx := context.Background()
+	top, deep := NewMemory(), NewMemory()
+	c := NewLayered(top, deep).WithWriteBehind(WriteBehindOptions{JournalDir: t.TempDir()})
+	if err := c.Put(ctx, "k", []byte("v")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err := c.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %s", err)
+	}
+	if data, _ := deep.Get(ctx, "k"); string(data) != "v" {
+		t.Fatalf("expected the write to reach the deepest layer, got %q", data)
+	}
+}
+
+func TestWriteBehindReplaysJournal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	// a previous process left a write behind
+	wb := newWriteBehind(nil, WriteBehindOptions{JournalDir: dir})
+	if err := wb.persist(&pendingWrite{Seq: 1, Op: OpPut, Key: "k", Data: []byte("v")}); err != nil {
+		t.Fatalf("persist failed: %s", err)
+	}
+
+	deep := NewMemory()
+	c := NewLayered(NewMemory(), deep).WithWriteBehind(WriteBehindOptions{JournalDir: dir})
+	if err := c.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %s", err)
+	}
+	if data, _ := deep.Get(ctx, "k"); string(data) != "v" {
+		t.Fatalf("expected the journaled write to be replayed, got %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected an empty journal, got %d entries", len(entries))
+	}
+}
+
+func TestWriteBehindKeepsDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c := NewLayered(NewMemory(), failingCache()).WithWriteBehind(WriteBehindOptions{
+		JournalDir:  dir,
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+	})
+	if err := c.Put(ctx, "k", []byte("v")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err := c.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %s", err)
+	}
+	dead, _ := filepath.Glob(filepath.Join(dir, "*"+writeBehindDeadLetterExt))
+	if len(dead) != 1 {
+		t.Fatalf("expected the write given up on to be kept, got %v", dead)
+	}
+
+	// dead letters are not replayed
+	deep := NewMemory()
+	NewLayered(NewMemory(), deep).WithWriteBehind(WriteBehindOptions{JournalDir: dir}).Flush(ctx)
+	if _, err := deep.Get(ctx, "k"); err == nil {
+		t.Fatal("expected the dead letter not to be replayed")
+	}
+}
+
+func TestWriteBehindKeepsDeadLettersAcrossRestarts(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	opts := WriteBehindOptions{JournalDir: dir, MaxAttempts: 1, Backoff: time.Millisecond}
+	// two processes in turn give up on a write
+	for _, key := range []string{"first", "second"} {
+		c := NewLayered(NewMemory(), failingCache()).WithWriteBehind(opts)
+		if err := c.Put(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+		if err := c.Flush(ctx); err != nil {
+			t.Fatalf("Flush failed: %s", err)
+		}
+	}
+	dead, _ := filepath.Glob(filepath.Join(dir, "*"+writeBehindDeadLetterExt))
+	if len(dead) != 2 {
+		t.Fatalf("expected both dead letters to be kept, got %v", dead)
+	}
+}
+
+func TestWriteBehindNeverReplacesDeadLetters(t *testing.T) {
+	dir := t.TempDir()
+	wb := newWriteBehind(nil, WriteBehindOptions{JournalDir: dir})
+	w := &pendingWrite{Seq: 1, Op: OpPut, Key: "k", Data: []byte("v")}
+	if err := wb.persist(w); err != nil {
+		t.Fatalf("persist failed: %s", err)
+	}
+	existing := filepath.Join(dir, "00000000000000000001"+writeBehindDeadLetterExt)
+	if err := os.WriteFile(existing, []byte("older"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.bury(w); err == nil {
+		t.Fatal("expected bury to refuse replacing a dead letter")
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "older" {
+		t.Fatalf("expected the existing dead letter to be kept, got %q", data)
+	}
+	if _, err := os.Stat(wb.journalPath(w)); err != nil {
+		t.Fatalf("expected the entry to stay in the journal, got %v", err)
+	}
+}